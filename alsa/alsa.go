@@ -25,18 +25,20 @@ var DriverName string = "alsa"
 
 // Alsa aoutput driter handler structure.
 type Alsa struct {
+	device string
 	handle *asoundlib.Handle
 	open   bool
 }
 
-// New returns newly initialized alsa output driver.
-func New() *Alsa {
-	return &Alsa{}
+// New returns newly initialized alsa output driver for the given
+// device (e.g. "default" or "hw:0,0").
+func New(device string) *Alsa {
+	return &Alsa{device: device}
 }
 
 func (a *Alsa) Open() error {
 	a.handle = asoundlib.New()
-	err := a.handle.Open("default", asoundlib.StreamTypePlayback, asoundlib.ModeBlock)
+	err := a.handle.Open(a.device, asoundlib.StreamTypePlayback, asoundlib.ModeBlock)
 	if err != nil {
 		return err
 	}
@@ -89,6 +91,10 @@ func (a *Alsa) Reset() {
 	a.handle.Reset()
 }
 
+func (a *Alsa) Drain() {
+	a.handle.Drain()
+}
+
 func (a *Alsa) Pause() {
 	a.handle.Pause()
 }
@@ -248,6 +248,16 @@ func (handle *Handle) Reset() {
 	// TODO: Error handling.
 }
 
+// Drain blocks until all pending frames are played.
+func (handle *Handle) Drain() error {
+	err := C.snd_pcm_drain(handle.cHandle)
+	if err < 0 {
+		return fmt.Errorf("drain failed: %s", strError(err))
+	}
+
+	return nil
+}
+
 // Pause PCM.
 func (handle *Handle) Pause() error {
 	var pause int
@@ -314,3 +324,106 @@ func strError(err _Ctype_int) string {
 
 	return C.GoString(cErrMsg)
 }
+
+// Mixer represents ALSA simple mixer element handler.
+type Mixer struct {
+	cHandle *C.snd_mixer_t
+	cElem   *C.snd_mixer_elem_t
+	min     C.long
+	max     C.long
+}
+
+// NewMixer returns newly initialized ALSA mixer handler.
+func NewMixer() *Mixer {
+	return new(Mixer)
+}
+
+// Open opens simple mixer element with the given name (e.g. "Master")
+// on the given card (e.g. "default").
+func (mixer *Mixer) Open(card string, elem string) error {
+	cCard := C.CString(card)
+	defer C.free(unsafe.Pointer(cCard))
+	cElem := C.CString(elem)
+	defer C.free(unsafe.Pointer(cElem))
+
+	err := C.snd_mixer_open(&(mixer.cHandle), 0)
+	if err < 0 {
+		return fmt.Errorf("Cannot open mixer. %s", strError(err))
+	}
+	err = C.snd_mixer_attach(mixer.cHandle, cCard)
+	if err < 0 {
+		mixer.Close()
+		return fmt.Errorf("Cannot attach mixer to '%s'. %s",
+			card, strError(err))
+	}
+	err = C.snd_mixer_selem_register(mixer.cHandle, nil, nil)
+	if err < 0 {
+		mixer.Close()
+		return fmt.Errorf("Cannot register mixer. %s", strError(err))
+	}
+	err = C.snd_mixer_load(mixer.cHandle)
+	if err < 0 {
+		mixer.Close()
+		return fmt.Errorf("Cannot load mixer elements. %s", strError(err))
+	}
+
+	var cId *C.snd_mixer_selem_id_t
+	err = C.snd_mixer_selem_id_malloc(&cId)
+	if err < 0 {
+		mixer.Close()
+		return fmt.Errorf("Cannot allocate mixer element id. %s",
+			strError(err))
+	}
+	defer C.snd_mixer_selem_id_free(cId)
+	C.snd_mixer_selem_id_set_index(cId, 0)
+	C.snd_mixer_selem_id_set_name(cId, cElem)
+
+	mixer.cElem = C.snd_mixer_find_selem(mixer.cHandle, cId)
+	if mixer.cElem == nil {
+		mixer.Close()
+		return fmt.Errorf("Cannot find mixer element '%s'.", elem)
+	}
+	err = C.snd_mixer_selem_get_playback_volume_range(mixer.cElem,
+		&mixer.min, &mixer.max)
+	if err < 0 || mixer.max <= mixer.min {
+		mixer.Close()
+		return fmt.Errorf("Cannot get volume range of '%s'.", elem)
+	}
+
+	return nil
+}
+
+// Volume returns current playback volume in 0-100 range.
+func (mixer *Mixer) Volume() (int, error) {
+	// Process pending events to see changes made by other applications.
+	C.snd_mixer_handle_events(mixer.cHandle)
+
+	var cVol C.long
+	err := C.snd_mixer_selem_get_playback_volume(mixer.cElem,
+		C.SND_MIXER_SCHN_FRONT_LEFT, &cVol)
+	if err < 0 {
+		return 0, fmt.Errorf("Cannot get volume. %s", strError(err))
+	}
+	vol := (cVol - mixer.min) * 100 / (mixer.max - mixer.min)
+
+	return int(vol), nil
+}
+
+// SetVolume sets playback volume for all channels. vol is in 0-100 range.
+func (mixer *Mixer) SetVolume(vol int) error {
+	cVol := mixer.min + C.long(vol)*(mixer.max-mixer.min)/100
+	err := C.snd_mixer_selem_set_playback_volume_all(mixer.cElem, cVol)
+	if err < 0 {
+		return fmt.Errorf("Cannot set volume. %s", strError(err))
+	}
+
+	return nil
+}
+
+// Close closes mixer and release the handler.
+func (mixer *Mixer) Close() {
+	if mixer.cHandle != nil {
+		C.snd_mixer_close(mixer.cHandle)
+		mixer.cHandle = nil
+	}
+}
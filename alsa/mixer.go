@@ -0,0 +1,64 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package alsa
+
+import (
+	"sync"
+
+	"github.com/vchimishuk/chub/alsa/asoundlib"
+)
+
+// Mixer is the hardware volume control driver, which uses
+// ALSA simple mixer interface.
+type Mixer struct {
+	mu     sync.Mutex
+	handle *asoundlib.Mixer
+}
+
+// NewMixer returns opened ALSA mixer for the given card (e.g. "default")
+// and simple mixer element (e.g. "Master").
+func NewMixer(card string, elem string) (*Mixer, error) {
+	h := asoundlib.NewMixer()
+	err := h.Open(card, elem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mixer{handle: h}, nil
+}
+
+func (m *Mixer) Volume() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.handle.Volume()
+}
+
+func (m *Mixer) SetVolume(vol int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.handle.SetVolume(vol)
+}
+
+func (m *Mixer) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handle.Close()
+}
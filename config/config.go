@@ -27,10 +27,10 @@ package config
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -46,6 +46,8 @@ func (err Error) Error() string {
 
 type Config struct {
 	data map[string]string
+	// Line numbers where keys are defined.
+	lines map[string]int
 }
 
 func (c *Config) Defined(name string) bool {
@@ -65,7 +67,11 @@ func (c *Config) String(name string, def string) string {
 
 func (c *Config) Int(name string, def int) (int, error) {
 	if val, ok := c.data[name]; ok {
-		return strconv.Atoi(val)
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, c.error(name, "not valid int value")
+		}
+		return i, nil
 	} else {
 		return def, nil
 	}
@@ -80,13 +86,52 @@ func (c *Config) Bool(name string, def bool) (bool, error) {
 		} else if val == "false" {
 			return false, nil
 		} else {
-			return false, errors.New("not valid bool value")
+			return false, c.error(name, "not valid bool value")
 		}
 	} else {
 		return def, nil
 	}
 }
 
+// Line returns number of the line the key is defined at.
+// Zero is returned for undefined keys.
+func (c *Config) Line(name string) int {
+	return c.lines[name]
+}
+
+// ValueError returns Error for invalid value of the given key.
+func (c *Config) ValueError(name string, msg string) error {
+	return c.error(name, fmt.Sprintf("%s: %s", name, msg))
+}
+
+// Check checks that all defined keys are from the known keys list.
+// Error is returned for the first (by the line number) unknown key found.
+func (c *Config) Check(known []string) error {
+	keys := make(map[string]bool, len(known))
+	for _, k := range known {
+		keys[k] = true
+	}
+
+	var unknown []string
+	for k := range c.data {
+		if !keys[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Slice(unknown, func(i, j int) bool {
+		return c.lines[unknown[i]] < c.lines[unknown[j]]
+	})
+
+	return c.error(unknown[0], "unknown key "+unknown[0])
+}
+
+func (c *Config) error(name string, msg string) error {
+	return &Error{Line: c.lines[name], Message: msg}
+}
+
 const spaceChars = " \t\n\v"
 
 func ParseFile(file string) (*Config, error) {
@@ -101,6 +146,7 @@ func ParseFile(file string) (*Config, error) {
 
 func Parse(reader io.Reader) (*Config, error) {
 	data := make(map[string]string)
+	lines := make(map[string]int)
 	in := bufio.NewReader(reader)
 	ln := 0
 
@@ -108,10 +154,10 @@ func Parse(reader io.Reader) (*Config, error) {
 		ln++
 		line, err := in.ReadString('\n')
 		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
+			if err != io.EOF {
 				return nil, err
+			} else if len(line) == 0 {
+				break
 			}
 		}
 
@@ -130,7 +176,8 @@ func Parse(reader io.Reader) (*Config, error) {
 		val := strings.Trim(parts[1], spaceChars)
 
 		data[key] = val
+		lines[key] = ln
 	}
 
-	return &Config{data: data}, nil
+	return &Config{data: data, lines: lines}, nil
 }
@@ -92,3 +92,47 @@ bar = baz
 		t.Fatal()
 	}
 }
+
+func TestCheck(t *testing.T) {
+	input := `
+foo = 1
+# A comment.
+bar = 2
+baz = 3
+qux = 4`
+
+	cfg, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Line("qux") != 6 {
+		t.Fatal()
+	}
+	if err := cfg.Check([]string{"foo", "bar", "baz", "qux"}); err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Check([]string{"foo", "qux"})
+	if e, ok := err.(*Error); !ok || e.Line != 4 {
+		t.Fatalf("line 4 error expected but %v got", err)
+	}
+}
+
+func TestValueError(t *testing.T) {
+	input := `
+foo = 1
+bar = baz
+`
+
+	cfg, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.Int("bar", 0)
+	if e, ok := err.(*Error); !ok || e.Line != 3 {
+		t.Fatalf("line 3 error expected but %v got", err)
+	}
+	err = cfg.ValueError("foo", "invalid")
+	if e, ok := err.(*Error); !ok || e.Line != 2 {
+		t.Fatalf("line 2 error expected but %v got", err)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package cue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// DetectCharsets are the charsets sheets not in UTF-8 or UTF-16 are
+// tried in when charset is not given. The one giving the most
+// sensible text wins, earlier charsets win ties.
+var DetectCharsets = []string{"CP1251", "CP932"}
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// toUTF8 converts cue-sheet data to UTF-8. UTF-8 and UTF-16 data is
+// recognized by BOM or content, any other data is considered to be
+// in the charset. Charset is detected if it is empty.
+func toUTF8(data []byte, charset string) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return data[len(bomUTF8):], nil
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return decodeUTF16(data[len(bomUTF16LE):], binary.LittleEndian)
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return decodeUTF16(data[len(bomUTF16BE):], binary.BigEndian)
+	}
+	if order := utf16Order(data); order != nil {
+		return decodeUTF16(data, order)
+	}
+	if utf8.Valid(data) {
+		return data, nil
+	}
+	if charset != "" {
+		return convert(data, charset)
+	}
+
+	return detect(data), nil
+}
+
+// utf16Order returns byte order of UTF-16 data without BOM or nil if
+// data does not look like UTF-16. Sheet commands are ASCII, so every
+// second byte of UTF-16 data is mostly zero.
+func utf16Order(data []byte) binary.ByteOrder {
+	if len(data) < 2 || len(data)%2 != 0 {
+		return nil
+	}
+	even, odd := 0, 0
+	for i := 0; i < len(data); i += 2 {
+		if data[i] == 0 {
+			even++
+		}
+		if data[i+1] == 0 {
+			odd++
+		}
+	}
+
+	n := len(data) / 2
+	if odd > n/2 && even == 0 {
+		return binary.LittleEndian
+	}
+	if even > n/2 && odd == 0 {
+		return binary.BigEndian
+	}
+
+	return nil
+}
+
+func decodeUTF16(data []byte, order binary.ByteOrder) ([]byte, error) {
+	if len(data)%2 != 0 {
+		return nil, errors.New("invalid UTF-16 data")
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[i*2:])
+	}
+
+	return []byte(string(utf16.Decode(units))), nil
+}
+
+// detect converts data from one of DetectCharsets to UTF-8.
+// Invalid characters are replaced with U+FFFD if data is
+// not valid in any of them.
+func detect(data []byte) []byte {
+	var best []byte
+	bestScore := -1.0
+	for _, cs := range DetectCharsets {
+		text, err := convert(data, cs)
+		if err != nil {
+			continue
+		}
+		if s := score(text); s > bestScore {
+			best = text
+			bestScore = s
+		}
+	}
+	if best == nil {
+		return bytes.ToValidUTF8(data, []byte("\uFFFD"))
+	}
+
+	return best
+}
+
+// score returns share of letters among non-ASCII characters of the
+// text. Text decoded with the wrong charset is full of punctuation,
+// half-width katakana and private use characters instead.
+func score(text []byte) float64 {
+	total, letters := 0, 0
+	for _, r := range string(text) {
+		if r < utf8.RuneSelf {
+			continue
+		}
+		total++
+		if unicode.IsLetter(r) && !unicode.Is(unicode.Co, r) &&
+			!(r >= 0xff61 && r <= 0xff9f) {
+			letters++
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+
+	return float64(letters) / float64(total)
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package cue
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func sheetTitle(t *testing.T, data string, charset string) string {
+	sheet, err := ParseCharset(strings.NewReader(data), 0, charset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sheet.Title
+}
+
+func TestCharset(t *testing.T) {
+	tests := []struct {
+		data    string
+		charset string
+		title   string
+	}{
+		{"TITLE \"\xc0\xf0\xe8\xff \x97 \xd8\xf2\xe8\xeb\xfc\"", "",
+			"Ария — Штиль"},
+		{"TITLE \"\x83\x8b\x83p\x83\x93\x8eO\x90\xa2\x82\xcc\x83e\x81[\x83}\"",
+			"", "ルパン三世のテーマ"},
+		{"TITLE \"\xca\xe8\xed\xee\"", "", "Кино"},
+		{"TITLE \"\xca\xe8\xed\xee\"", "KOI8-R", "йХМН"},
+		{"\xef\xbb\xbfTITLE \"Кино\"", "KOI8-R", "Кино"},
+		{"TITLE \"Кино\"", "CP1251", "Кино"},
+	}
+	for _, tt := range tests {
+		title := sheetTitle(t, tt.data, tt.charset)
+		if title != tt.title {
+			t.Fatalf("'%s' expected but '%s' got", tt.title, title)
+		}
+	}
+
+	if _, err := ParseCharset(strings.NewReader("TITLE \"\xff\""), 0, "UTF-8"); err == nil {
+		t.Fatal()
+	}
+	if CheckCharset("NO-SUCH-CHARSET") == nil {
+		t.Fatal()
+	}
+}
+
+func TestCharsetUTF16(t *testing.T) {
+	text := "TITLE \"ルパン三世\"\r\n"
+	units := utf16.Encode([]rune(text))
+	le := []byte{0xff, 0xfe}
+	be := []byte{0xfe, 0xff}
+	var noBOM []byte
+	for _, u := range units {
+		le = append(le, byte(u), byte(u>>8))
+		be = append(be, byte(u>>8), byte(u))
+		noBOM = append(noBOM, byte(u), byte(u>>8))
+	}
+
+	for _, data := range [][]byte{le, be, noBOM} {
+		title := sheetTitle(t, string(data), "CP1251")
+		if title != "ルパン三世" {
+			t.Fatalf("'ルパン三世' expected but '%s' got", title)
+		}
+	}
+}
@@ -23,9 +23,11 @@ package cue
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
@@ -67,20 +69,43 @@ const (
 
 // ParseFile parses cue-sheet tile.
 func ParseFile(filename string, flags int) (sheet *Sheet, err error) {
+	return ParseFileCharset(filename, flags, "")
+}
+
+// ParseFileCharset parses cue-sheet file in the given charset.
+// See ParseCharset for details.
+func ParseFileCharset(filename string, flags int, charset string) (sheet *Sheet, err error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	return Parse(file, flags)
+	return ParseCharset(file, flags, charset)
 }
 
 // Parse parses cue-sheet data from reader and returns filled Sheet struct.
+// Charset of the data is detected.
 func Parse(reader io.Reader, flags int) (sheet *Sheet, err error) {
+	return ParseCharset(reader, flags, "")
+}
+
+// ParseCharset parses cue-sheet data in the given charset from reader.
+// UTF-8 and UTF-16 data is recognized regardless of the charset.
+// Charset is detected if it is empty. All strings of the returned
+// Sheet are in UTF-8.
+func ParseCharset(reader io.Reader, flags int, charset string) (sheet *Sheet, err error) {
 	sheet = &Sheet{flags: flags}
 
-	rd := bufio.NewReader(reader)
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err = toUTF8(data, charset)
+	if err != nil {
+		return nil, err
+	}
+	rd := bufio.NewReader(bytes.NewReader(data))
 	lineNumber := 1
 
 	for buf, _, err := rd.ReadLine(); err != io.EOF; buf, _, err = rd.ReadLine() {
@@ -236,9 +261,11 @@ func parseIndex(params []string, sheet *Sheet) error {
 		return fmt.Errorf("TRACK command expected.")
 	}
 
+	file := getCurrentFile(sheet)
+
 	// The first index of a file must start at 00:00:00.
 	// Ignore this error, some CUE sheets don't have it
-	if getFileLastIndex(getCurrentFile(sheet)) == nil {
+	if getFileLastIndex(sheet, file) == nil {
 		if min+sec+frames != 0 {
 			log.Printf("INDEX 00 00:00:00 missing")
 		}
@@ -260,6 +287,7 @@ func parseIndex(params []string, sheet *Sheet) error {
 
 	track.Indexes = append(track.Indexes, &Index{
 		Number: number,
+		File:   file,
 		Time: &Time{
 			Min:    min,
 			Sec:    sec,
@@ -448,10 +476,10 @@ func parseTrack(params []string, sheet *Sheet) error {
 	file := sheet.Files[len(sheet.Files)-1]
 
 	// But all track numbers after the first must be sequential.
-	if len(file.Tracks) > 0 {
-		if file.Tracks[len(file.Tracks)-1].Number != number-1 {
+	if prev := getCurrentTrack(sheet); prev != nil {
+		if prev.Number != number-1 {
 			return fmt.Errorf("Expected track number %d, but %d received.",
-				number-1, number)
+				prev.Number+1, number)
 		}
 	}
 
@@ -471,28 +499,30 @@ func getCurrentFile(sheet *Sheet) *File {
 }
 
 // getCurrentTrack returns current track object, which was started with last TRACK command.
+// Track can be started in one of previous files, if its indexes span several files.
 // Returns nil if there is no any Track object avaliable.
 func getCurrentTrack(sheet *Sheet) *Track {
-	file := getCurrentFile(sheet)
-	if file == nil {
-		return nil
-	}
-
-	if len(file.Tracks) == 0 {
-		return nil
+	for i := len(sheet.Files) - 1; i >= 0; i-- {
+		file := sheet.Files[i]
+		if len(file.Tracks) > 0 {
+			return file.Tracks[len(file.Tracks)-1]
+		}
 	}
 
-	return file.Tracks[len(file.Tracks)-1]
+	return nil
 }
 
 // getFileLastIndex returns last index for the given file.
 // Returns nil if file has no any indexes.
-func getFileLastIndex(file *File) *Index {
-	for i := len(file.Tracks) - 1; i >= 0; i-- {
-		track := file.Tracks[i]
+func getFileLastIndex(sheet *Sheet, file *File) *Index {
+	tracks := sheet.Tracks()
+	for i := len(tracks) - 1; i >= 0; i-- {
+		track := tracks[i]
 
 		for j := len(track.Indexes) - 1; j >= 0; j-- {
-			return track.Indexes[j]
+			if track.Indexes[j].File == file {
+				return track.Indexes[j]
+			}
 		}
 	}
 
@@ -99,3 +99,56 @@ func TestPackage(t *testing.T) {
 			time.Min, time.Sec, time.Frames)
 	}
 }
+
+func TestGaps(t *testing.T) {
+	sheet, err := ParseFile("gaps.cue", 0)
+	if err != nil {
+		t.Fatalf("Failed to parse file. %s", err)
+	}
+
+	tracks := sheet.Tracks()
+	if len(tracks) != 3 {
+		t.Fatalf("Expected tracks number 3 but %d got.", len(tracks))
+	}
+	if tracks[0].Index(0).Time.TotalFrames() != 0 ||
+		tracks[0].Index(1).Time.TotalFrames() != 750 {
+		t.Fatal("Invalid first track indexes.")
+	}
+	if tracks[1].Index(0).Time.TotalFrames() != 15000 ||
+		tracks[1].Index(1).Time.TotalFrames() != 15180 {
+		t.Fatal("Invalid second track indexes.")
+	}
+	if tracks[1].Postgap == nil || tracks[1].Postgap.TotalFrames() != 75 {
+		t.Fatal("Invalid second track postgap.")
+	}
+	if tracks[2].Pregap == nil || tracks[2].Pregap.TotalFrames() != 150 ||
+		tracks[2].Index(0) != nil {
+		t.Fatal("Invalid third track pregap.")
+	}
+}
+
+func TestMultiFile(t *testing.T) {
+	sheet, err := ParseFile("multifile.cue", 0)
+	if err != nil {
+		t.Fatalf("Failed to parse file. %s", err)
+	}
+
+	if len(sheet.Files) != 2 || len(sheet.Files[0].Tracks) != 2 ||
+		len(sheet.Files[1].Tracks) != 1 {
+		t.Fatal("Invalid files.")
+	}
+	tracks := sheet.Tracks()
+	if len(tracks) != 3 || tracks[2].Number != 3 {
+		t.Fatal("Invalid tracks.")
+	}
+	// The second track's gap is at the end of the first file.
+	track := tracks[1]
+	if track.Index(0).File != sheet.Files[0] ||
+		track.Index(1).File != sheet.Files[1] ||
+		track.Index(1).Time.TotalFrames() != 0 {
+		t.Fatal("Invalid second track indexes.")
+	}
+	if tracks[2].Index(1).File != sheet.Files[1] {
+		t.Fatal("Invalid third track indexes.")
+	}
+}
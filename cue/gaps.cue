@@ -0,0 +1,16 @@
+PERFORMER "Warlock"
+TITLE "Triumph and Agony"
+FILE "album.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "All We Are"
+    INDEX 00 00:00:00
+    INDEX 01 00:10:00
+  TRACK 02 AUDIO
+    TITLE "Three Minute Warning"
+    INDEX 00 03:20:00
+    INDEX 01 03:22:30
+    POSTGAP 00:01:00
+  TRACK 03 AUDIO
+    TITLE "I Rule the Ruins"
+    PREGAP 00:02:00
+    INDEX 01 07:00:00
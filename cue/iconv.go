@@ -0,0 +1,107 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package cue
+
+// #include <errno.h>
+// #include <iconv.h>
+// #include <stdlib.h>
+import "C"
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// iconvOpen returns conversion descriptor from the charset to UTF-8.
+func iconvOpen(charset string) (C.iconv_t, error) {
+	to := C.CString("UTF-8")
+	defer C.free(unsafe.Pointer(to))
+	from := C.CString(charset)
+	defer C.free(unsafe.Pointer(from))
+
+	cd, err := C.iconv_open(to, from)
+	if uintptr(unsafe.Pointer(cd)) == ^uintptr(0) {
+		if err == syscall.EINVAL {
+			return cd, fmt.Errorf("unsupported charset %s", charset)
+		}
+		return cd, err
+	}
+
+	return cd, nil
+}
+
+// CheckCharset returns error if conversion from the charset
+// is not supported.
+func CheckCharset(charset string) error {
+	cd, err := iconvOpen(charset)
+	if err != nil {
+		return err
+	}
+	C.iconv_close(cd)
+
+	return nil
+}
+
+// convert converts data from the charset to UTF-8. Data with
+// characters invalid in the charset is an error.
+func convert(data []byte, charset string) ([]byte, error) {
+	cd, err := iconvOpen(charset)
+	if err != nil {
+		return nil, err
+	}
+	defer C.iconv_close(cd)
+
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	// iconv updates buffer pointers, so they have to be in C memory.
+	in := C.CBytes(data)
+	defer C.free(in)
+	const bufSize = 4096
+	buf := C.malloc(bufSize)
+	defer C.free(buf)
+
+	inp := (*C.char)(in)
+	inLeft := C.size_t(len(data))
+	out := make([]byte, 0, len(data)*2)
+	for {
+		outp := (*C.char)(buf)
+		outLeft := C.size_t(bufSize)
+		var err error
+		if inLeft > 0 {
+			_, err = C.iconv(cd, &inp, &inLeft, &outp, &outLeft)
+		} else {
+			// Flush shift state of stateful encodings.
+			_, err = C.iconv(cd, nil, nil, &outp, &outLeft)
+		}
+		out = append(out, C.GoBytes(buf, C.int(bufSize-outLeft))...)
+		if err == syscall.E2BIG {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s data", charset)
+		}
+		if inLeft == 0 && outLeft == bufSize {
+			break
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,14 @@
+PERFORMER "Doro"
+TITLE "Force Majeure"
+FILE "01.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "A Whiter Shade of Pale"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Save My Soul"
+    INDEX 00 04:30:00
+FILE "02.flac" WAVE
+    INDEX 01 00:00:00
+  TRACK 03 AUDIO
+    TITLE "World Gone Wild"
+    INDEX 01 03:50:00
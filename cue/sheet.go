@@ -37,6 +37,16 @@ type Sheet struct {
 	flags int
 }
 
+// Tracks returns tracks of all files in order.
+func (sheet *Sheet) Tracks() []*Track {
+	var tracks []*Track
+	for _, f := range sheet.Files {
+		tracks = append(tracks, f.Tracks...)
+	}
+
+	return tracks
+}
+
 // Type of the audio file.
 type FileType int
 
@@ -85,15 +95,27 @@ type Time struct {
 	Frames int
 }
 
+// Number of frames in a second.
+const FramesPerSecond = 75
+
 // Seconds returns length in seconds.
 func (time *Time) Seconds() int {
 	return time.Min*60 + time.Sec
 }
 
+// TotalFrames returns length in frames.
+func (time *Time) TotalFrames() int {
+	return time.Seconds()*FramesPerSecond + time.Frames
+}
+
 // Track index type
 type Index struct {
 	// Index number.
 	Number int
+	// File the index points into. Indexes of the same track can
+	// point into different files, e.g. INDEX 00 of the track can be
+	// at the end of the previous track's file.
+	File *File
 	// Index starting time.
 	Time *Time
 }
@@ -137,6 +159,17 @@ type Track struct {
 	Comments []string
 }
 
+// Index returns the track's index with the given number or nil.
+func (track *Track) Index(number int) *Index {
+	for _, i := range track.Indexes {
+		if i.Number == number {
+			return i
+		}
+	}
+
+	return nil
+}
+
 // Audio file representation structure.
 type File struct {
 	// Name (path) of the file.
@@ -0,0 +1,186 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package cue
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var fileTypeNames = map[FileType]string{
+	FileTypeBinary:   "BINARY",
+	FileTypeMotorola: "MOTOROLA",
+	FileTypeAiff:     "AIFF",
+	FileTypeWave:     "WAVE",
+	FileTypeMp3:      "MP3",
+}
+
+var dataTypeNames = map[TrackDataType]string{
+	DataTypeAudio:      "AUDIO",
+	DataTypeCdg:        "CDG",
+	DataTypeMode1_2048: "MODE1/2048",
+	DataTypeMode1_2352: "MODE1/2352",
+	DataTypeMode2_2336: "MODE2/2336",
+	DataTypeMode2_2352: "MODE2/2352",
+	DataTypeCdi_2336:   "CDI/2336",
+	DataTypeCdi_2352:   "CDI/2352",
+}
+
+var trackFlagNames = map[TrackFlag]string{
+	TrackFlagDcp:  "DCP",
+	TrackFlag4ch:  "4CH",
+	TrackFlagPre:  "PRE",
+	TrackFlagScms: "SCMS",
+}
+
+// sheetWriter writes cue-sheet commands remembering the first error.
+type sheetWriter struct {
+	wr  *bufio.Writer
+	err error
+}
+
+// command writes indented command line. Parameters are written as is.
+func (w *sheetWriter) command(indent int, cmd string, params ...string) {
+	if w.err != nil {
+		return
+	}
+	line := strings.Repeat("  ", indent) + cmd
+	if len(params) > 0 {
+		line += " " + strings.Join(params, " ")
+	}
+	_, w.err = w.wr.WriteString(line + "\n")
+}
+
+// text writes string command if the string is not empty.
+func (w *sheetWriter) text(indent int, cmd string, s string) {
+	if len(s) > 0 {
+		w.command(indent, cmd, quote(s))
+	}
+}
+
+// comments writes REM commands. The first word of the comment is
+// written as is and the rest is quoted, so the comment is parsed back
+// as the same string: "GENRE Hard Rock" -> REM GENRE "Hard Rock".
+func (w *sheetWriter) comments(indent int, comments []string) {
+	for _, c := range comments {
+		f := strings.SplitN(c, " ", 2)
+		if len(f[0]) == 0 {
+			// Empty parameter can't be followed by others.
+			f = []string{c}
+		}
+		params := []string{quoteMaybe(f[0])}
+		if len(f) == 2 {
+			params = append(params, quoteMaybe(f[1]))
+		}
+		w.command(indent, "REM", params...)
+	}
+}
+
+// Write writes the sheet in cue-sheet format. Parse of the written
+// data returns the same Sheet.
+func Write(writer io.Writer, sheet *Sheet) error {
+	w := &sheetWriter{wr: bufio.NewWriter(writer)}
+
+	w.comments(0, sheet.Comments)
+	if len(sheet.Catalog) > 0 {
+		w.command(0, "CATALOG", sheet.Catalog)
+	}
+	w.text(0, "CDTEXTFILE", sheet.CdTextFile)
+	w.text(0, "PERFORMER", sheet.Performer)
+	w.text(0, "SONGWRITER", sheet.Songwriter)
+	w.text(0, "TITLE", sheet.Title)
+
+	// Indexes of a track can be in the next file, so FILE command
+	// is written before the first index pointing into it.
+	var cur *File
+	file := func(f *File) {
+		if f != cur {
+			w.command(0, "FILE", quote(f.Name), fileTypeNames[f.Type])
+			cur = f
+		}
+	}
+	for _, f := range sheet.Files {
+		file(f)
+		for _, t := range f.Tracks {
+			writeTrack(w, t, file)
+		}
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.wr.Flush()
+}
+
+func writeTrack(w *sheetWriter, t *Track, file func(f *File)) {
+	w.command(1, "TRACK", fmt.Sprintf("%02d", t.Number),
+		dataTypeNames[t.DataType])
+	if len(t.Flags) > 0 {
+		flags := make([]string, 0, len(t.Flags))
+		for _, f := range t.Flags {
+			flags = append(flags, trackFlagNames[f])
+		}
+		w.command(2, "FLAGS", flags...)
+	}
+	if len(t.Isrc) > 0 {
+		w.command(2, "ISRC", t.Isrc)
+	}
+	w.text(2, "TITLE", t.Title)
+	w.text(2, "PERFORMER", t.Performer)
+	w.text(2, "SONGWRITER", t.Songwriter)
+	w.comments(2, t.Comments)
+	if t.Pregap != nil {
+		w.command(2, "PREGAP", formatTime(t.Pregap))
+	}
+	for _, i := range t.Indexes {
+		if i.File != nil {
+			file(i.File)
+		}
+		w.command(2, "INDEX", fmt.Sprintf("%02d", i.Number),
+			formatTime(i.Time))
+	}
+	if t.Postgap != nil {
+		w.command(2, "POSTGAP", formatTime(t.Postgap))
+	}
+}
+
+// formatTime returns time string in mm:ss:ff format.
+func formatTime(t *Time) string {
+	return fmt.Sprintf("%02d:%02d:%02d", t.Min, t.Sec, t.Frames)
+}
+
+// quote returns quoted string with quotes and special characters
+// escaped.
+func quote(s string) string {
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"",
+		"\n", "\\n", "\t", "\\t")
+
+	return "\"" + r.Replace(s) + "\""
+}
+
+// quoteMaybe returns the string quoted if it is not a single word
+// of ordinary characters.
+func quoteMaybe(s string) string {
+	if len(s) == 0 || strings.ContainsAny(s, " \t\n\r\"'\\") {
+		return quote(s)
+	}
+
+	return s
+}
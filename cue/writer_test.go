@@ -0,0 +1,52 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package cue
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	for _, name := range []string{"test.cue", "gaps.cue", "multifile.cue"} {
+		sheet, err := ParseFile(name, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet.Comments = append(sheet.Comments, "COMMENT \"quoted\\ \"",
+			" leading space", "")
+		sheet.Catalog = "1234567890123"
+		sheet.Tracks()[0].Isrc = "USRC17607839"
+		sheet.Tracks()[0].Flags = []TrackFlag{TrackFlagDcp, TrackFlagPre}
+		sheet.Tracks()[0].Title = "Tab\tand \"quotes\""
+
+		var buf bytes.Buffer
+		err = Write(&buf, sheet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written, err := Parse(&buf, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sheet, written) {
+			t.Fatalf("%s: written sheet differs", name)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2019 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+#include <stdlib.h>
+#include <string.h>
+#include <libavutil/opt.h>
+#include <libavcodec/avcodec.h>
+#include "encoder.h"
+
+// Stream sample rate unless codec does not support it.
+#define ENCODER_SAMPLE_RATE 44100
+// Number of samples passed to codecs without fixed frame size.
+#define ENCODER_FRAME_SIZE 1024
+#define ENCODER_IO_SIZE 4096
+
+static int ffmpeg_encoder_output(void *opaque, uint8_t *buf, int size)
+{
+    struct ffmpeg_encoder *enc = opaque;
+
+    if (enc->out_len + size > enc->out_cap) {
+        int cap = FFMAX(enc->out_cap * 2, enc->out_len + size);
+        uint8_t *out = realloc(enc->out, cap);
+        if (out == NULL) {
+            return AVERROR(ENOMEM);
+        }
+        enc->out = out;
+        enc->out_cap = cap;
+    }
+    memcpy(enc->out + enc->out_len, buf, size);
+    enc->out_len += size;
+
+    return size;
+}
+
+static int ffmpeg_encoder_sample_rate(AVCodec *codec)
+{
+    const int *r = codec->supported_samplerates;
+    if (r == NULL) {
+        return ENCODER_SAMPLE_RATE;
+    }
+    for (int i = 0; r[i] != 0; i++) {
+        if (r[i] == ENCODER_SAMPLE_RATE) {
+            return ENCODER_SAMPLE_RATE;
+        }
+    }
+
+    return r[0];
+}
+
+// ffmpeg_encoder_open returns encoder which muxes stereo stream into
+// the format (ffmpeg muxer name, e.g. "mp3", "ogg" or "wav") with the
+// format's default audio codec. bitrate is in bits per second, 0 means
+// codec default one. NULL is returned on error.
+struct ffmpeg_encoder *ffmpeg_encoder_open(const char *format, int bitrate)
+{
+    struct ffmpeg_encoder *enc = calloc(1, sizeof(struct ffmpeg_encoder));
+    if (enc == NULL) {
+        return NULL;
+    }
+
+    if (avformat_alloc_output_context2(&enc->format, NULL, format, NULL) < 0) {
+        ffmpeg_encoder_close(enc);
+        return NULL;
+    }
+    AVCodec *codec = avcodec_find_encoder(enc->format->oformat->audio_codec);
+    if (codec == NULL) {
+        ffmpeg_encoder_close(enc);
+        return NULL;
+    }
+    enc->codec = avcodec_alloc_context3(codec);
+    enc->stream = avformat_new_stream(enc->format, NULL);
+    if (enc->codec == NULL || enc->stream == NULL) {
+        ffmpeg_encoder_close(enc);
+        return NULL;
+    }
+
+    AVCodecContext *c = enc->codec;
+    c->sample_fmt = codec->sample_fmts ? codec->sample_fmts[0]
+        : AV_SAMPLE_FMT_S16;
+    c->sample_rate = ffmpeg_encoder_sample_rate(codec);
+    c->channel_layout = AV_CH_LAYOUT_STEREO;
+    c->channels = 2;
+    c->time_base = (AVRational) {1, c->sample_rate};
+    c->strict_std_compliance = FF_COMPLIANCE_EXPERIMENTAL;
+    if (bitrate > 0) {
+        c->bit_rate = bitrate;
+    }
+    if (enc->format->oformat->flags & AVFMT_GLOBALHEADER) {
+        c->flags |= AV_CODEC_FLAG_GLOBAL_HEADER;
+    }
+    if (avcodec_open2(c, codec, NULL) < 0
+        || avcodec_parameters_from_context(enc->stream->codecpar, c) < 0) {
+        ffmpeg_encoder_close(enc);
+        return NULL;
+    }
+    enc->stream->time_base = c->time_base;
+
+    enc->frame_size = c->frame_size > 0 ? c->frame_size : ENCODER_FRAME_SIZE;
+    enc->fifo = av_audio_fifo_alloc(c->sample_fmt, c->channels,
+            enc->frame_size);
+    enc->frame = av_frame_alloc();
+    if (enc->fifo == NULL || enc->frame == NULL) {
+        ffmpeg_encoder_close(enc);
+        return NULL;
+    }
+    enc->frame->nb_samples = enc->frame_size;
+    enc->frame->format = c->sample_fmt;
+    enc->frame->channel_layout = c->channel_layout;
+    enc->frame->sample_rate = c->sample_rate;
+    if (av_frame_get_buffer(enc->frame, 0) < 0) {
+        ffmpeg_encoder_close(enc);
+        return NULL;
+    }
+
+    uint8_t *iobuf = av_malloc(ENCODER_IO_SIZE);
+    enc->format->pb = avio_alloc_context(iobuf, ENCODER_IO_SIZE, 1, enc,
+            NULL, ffmpeg_encoder_output, NULL);
+    if (enc->format->pb == NULL) {
+        av_free(iobuf);
+        ffmpeg_encoder_close(enc);
+        return NULL;
+    }
+    enc->format->flags |= AVFMT_FLAG_CUSTOM_IO | AVFMT_FLAG_FLUSH_PACKETS;
+
+    // Stream is endless, so there is nothing to put into ID3 and Xing
+    // headers.
+    AVDictionary *opts = NULL;
+    av_dict_set(&opts, "id3v2_version", "0", 0);
+    av_dict_set(&opts, "write_xing", "0", 0);
+    int err = avformat_write_header(enc->format, &opts);
+    av_dict_free(&opts);
+    if (err < 0) {
+        ffmpeg_encoder_close(enc);
+        return NULL;
+    }
+    avio_flush(enc->format->pb);
+    enc->header = enc->out;
+    enc->header_len = enc->out_len;
+    enc->out = NULL;
+    enc->out_len = 0;
+    enc->out_cap = 0;
+
+    return enc;
+}
+
+static int ffmpeg_encoder_setup(struct ffmpeg_encoder *enc, int rate,
+        int channels)
+{
+    if (enc->swr != NULL && enc->in_rate == rate
+        && enc->in_channels == channels) {
+        return 0;
+    }
+    swr_free(&enc->swr);
+    enc->swr = swr_alloc_set_opts(NULL,
+            enc->codec->channel_layout, enc->codec->sample_fmt,
+            enc->codec->sample_rate,
+            av_get_default_channel_layout(channels), AV_SAMPLE_FMT_S16,
+            rate, 0, NULL);
+    if (enc->swr == NULL || swr_init(enc->swr) < 0) {
+        swr_free(&enc->swr);
+        return -1;
+    }
+    enc->in_rate = rate;
+    enc->in_channels = channels;
+
+    return 0;
+}
+
+// ffmpeg_encoder_encode passes full frames from the FIFO to the codec
+// and muxes encoded packets.
+static int ffmpeg_encoder_encode(struct ffmpeg_encoder *enc)
+{
+    AVPacket pkt;
+
+    while (av_audio_fifo_size(enc->fifo) >= enc->frame_size) {
+        if (av_frame_make_writable(enc->frame) < 0) {
+            return -1;
+        }
+        av_audio_fifo_read(enc->fifo, (void **) enc->frame->data,
+                enc->frame_size);
+        enc->frame->pts = enc->pts;
+        enc->pts += enc->frame_size;
+        if (avcodec_send_frame(enc->codec, enc->frame) < 0) {
+            return -1;
+        }
+
+        av_init_packet(&pkt);
+        pkt.data = NULL;
+        pkt.size = 0;
+        while (avcodec_receive_packet(enc->codec, &pkt) == 0) {
+            av_packet_rescale_ts(&pkt, enc->codec->time_base,
+                    enc->stream->time_base);
+            pkt.stream_index = enc->stream->index;
+            int err = av_write_frame(enc->format, &pkt);
+            av_packet_unref(&pkt);
+            if (err < 0) {
+                return -1;
+            }
+        }
+    }
+    avio_flush(enc->format->pb);
+
+    return 0;
+}
+
+// ffmpeg_encoder_write encodes signed 16 bit interleaved PCM data
+// with the given sample rate and number of channels. Encoded data is
+// appended to the encoder's output buffer.
+int ffmpeg_encoder_write(struct ffmpeg_encoder *enc, char *buf, int len,
+        int rate, int channels)
+{
+    if (ffmpeg_encoder_setup(enc, rate, channels) < 0) {
+        return -1;
+    }
+
+    int in_samples = len / (2 * channels);
+    int out_samples = av_rescale_rnd(
+            swr_get_delay(enc->swr, rate) + in_samples,
+            enc->codec->sample_rate, rate, AV_ROUND_UP);
+    uint8_t **out = NULL;
+    if (av_samples_alloc_array_and_samples(&out, NULL, enc->codec->channels,
+                out_samples, enc->codec->sample_fmt, 0) < 0) {
+        return -1;
+    }
+    const uint8_t *in = (const uint8_t *) buf;
+    int n = swr_convert(enc->swr, out, out_samples, &in, in_samples);
+    if (n > 0) {
+        n = av_audio_fifo_write(enc->fifo, (void **) out, n);
+    }
+    av_freep(&out[0]);
+    av_freep(&out);
+    if (n < 0) {
+        return -1;
+    }
+
+    return ffmpeg_encoder_encode(enc);
+}
+
+void ffmpeg_encoder_close(struct ffmpeg_encoder *enc)
+{
+    if (enc->format) {
+        if (enc->format->pb) {
+            av_freep(&enc->format->pb->buffer);
+            av_freep(&enc->format->pb);
+        }
+        avformat_free_context(enc->format);
+    }
+    if (enc->codec) {
+        avcodec_free_context(&enc->codec);
+    }
+    swr_free(&enc->swr);
+    if (enc->fifo) {
+        av_audio_fifo_free(enc->fifo);
+    }
+    av_frame_free(&enc->frame);
+    free(enc->header);
+    free(enc->out);
+    free(enc);
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package ffmpeg
+
+// #include <stdlib.h>
+// #include "ffmpeg.h"
+// #include "encoder.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/vchimishuk/chub/format"
+)
+
+// Stream MIME types of supported encoder formats.
+var encoderMIMEs = map[string]string{
+	"wav":  "audio/wav",
+	"mp3":  "audio/mpeg",
+	"ogg":  "audio/ogg",
+	"adts": "audio/aac",
+	"flac": "audio/flac",
+}
+
+type encoder struct {
+	enc  *C.struct_ffmpeg_encoder
+	mime string
+}
+
+// NewEncoder returns stereo stream encoder for the given format,
+// which is one of "wav", "mp3", "ogg" (Vorbis), "adts" (AAC) or "flac".
+// Bitrate is in kbit/s, 0 means codec default one.
+func NewEncoder(name string, bitrate int) (format.Encoder, error) {
+	mime, ok := encoderMIMEs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported stream format %s", name)
+	}
+	C.ffmpeg_init()
+
+	n := C.CString(name)
+	defer C.free(unsafe.Pointer(n))
+	enc := C.ffmpeg_encoder_open(n, C.int(bitrate*1000))
+	if enc == nil {
+		return nil, fmt.Errorf("failed to open %s encoder", name)
+	}
+
+	return &encoder{enc: enc, mime: mime}, nil
+}
+
+func (e *encoder) MIME() string {
+	return e.mime
+}
+
+func (e *encoder) Header() []byte {
+	return C.GoBytes(unsafe.Pointer(e.enc.header), e.enc.header_len)
+}
+
+func (e *encoder) Encode(buf []byte, rate int, channels int) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	p := (*C.char)(unsafe.Pointer(&buf[0]))
+	if C.ffmpeg_encoder_write(e.enc, p, C.int(len(buf)),
+		C.int(rate), C.int(channels)) != 0 {
+		return nil, errors.New("failed to encode stream")
+	}
+	data := C.GoBytes(unsafe.Pointer(e.enc.out), e.enc.out_len)
+	e.enc.out_len = 0
+
+	return data, nil
+}
+
+func (e *encoder) Close() {
+	C.ffmpeg_encoder_close(e.enc)
+}
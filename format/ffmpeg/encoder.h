@@ -0,0 +1,52 @@
+// Copyright 2019 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+#ifndef CHUB_FFMPEG_ENCODER_H
+#define CHUB_FFMPEG_ENCODER_H
+
+#include <libavformat/avformat.h>
+#include <libavutil/audio_fifo.h>
+#include <libswresample/swresample.h>
+
+struct ffmpeg_encoder {
+    AVFormatContext *format;
+    AVCodecContext *codec;
+    AVStream *stream;
+    /* Converter from the input PCM format to the codec one. */
+    SwrContext *swr;
+    int in_rate;
+    int in_channels;
+    /* Converted samples waiting for the full codec frame. */
+    AVAudioFifo *fifo;
+    AVFrame *frame;
+    int frame_size;
+    int64_t pts;
+    /* Stream header written by the muxer. */
+    uint8_t *header;
+    int header_len;
+    /* Muxed data not taken by the caller yet. */
+    uint8_t *out;
+    int out_len;
+    int out_cap;
+};
+
+struct ffmpeg_encoder *ffmpeg_encoder_open(const char *format, int bitrate);
+int ffmpeg_encoder_write(struct ffmpeg_encoder *enc, char *buf, int len,
+        int rate, int channels);
+void ffmpeg_encoder_close(struct ffmpeg_encoder *enc);
+
+#endif // CHUB_FFMPEG_ENCODER_H
@@ -16,6 +16,7 @@
 // along with Chub. If not, see <http://www.gnu.org/licenses/>.
 
 #include <sys/param.h>
+#include <libavutil/channel_layout.h>
 #include <libavutil/opt.h>
 #include <libavutil/rational.h>
 #include <libavcodec/avcodec.h>
@@ -61,6 +62,9 @@ static int ffmpeg_decode(struct ffmpeg_file *file)
     file->pkt_decoded += e;
 
     AVFrame *frame = file->frame;
+    int sample_size = file->channels * 2;
+    // Samples of the previous frame.
+    int prev_nsamples = file->buf_len / sample_size;
     int delay_nsamples = swr_get_delay(file->swr, file->codec->sample_rate);
     int dst_nsamples = av_rescale_rnd(delay_nsamples + frame->nb_samples,
             file->sample_rate, file->codec->sample_rate, AV_ROUND_UP);
@@ -86,8 +90,29 @@ static int ffmpeg_decode(struct ffmpeg_file *file)
     }
     file->buf_len = nb;
     file->buf_offset = 0;
-    if (file->frame->pts > 0) {
-        file->time = file->frame->pts;
+
+    AVStream *s = file->format->streams[file->stream];
+    int64_t ts = frame->best_effort_timestamp;
+    if (ts != AV_NOPTS_VALUE) {
+        if (s->start_time != AV_NOPTS_VALUE) {
+            ts -= s->start_time;
+        }
+        file->buf_pos = av_rescale_q(ts, s->time_base,
+                (AVRational) {1, file->sample_rate});
+    } else {
+        file->buf_pos += prev_nsamples;
+    }
+    if (file->seek_pos >= 0) {
+        // Drop samples preceding the seek position.
+        int64_t skip = file->seek_pos - file->buf_pos;
+        if (skip >= ns) {
+            file->buf_offset = file->buf_len;
+        } else {
+            if (skip > 0) {
+                file->buf_offset = skip * sample_size;
+            }
+            file->seek_pos = -1;
+        }
     }
 
     return nb;
@@ -109,21 +134,37 @@ void ffmpeg_init()
 
 void ffmpeg_metadata_free(struct ffmpeg_metadata *md)
 {
-    if (md->artist) {
-        free(md->artist);
-    }
-    if (md->album) {
-        free(md->album);
-    }
-    if (md->title) {
-        free(md->title);
-    }
-    if (md->number) {
-        free(md->number);
+    for (int i = 0; i < md->len; i++) {
+        free(md->keys[i]);
+        free(md->values[i]);
     }
+    free(md->keys);
+    free(md->values);
     free(md);
 }
 
+char *ffmpeg_metadata_key(struct ffmpeg_metadata *md, int i)
+{
+    return md->keys[i];
+}
+
+char *ffmpeg_metadata_value(struct ffmpeg_metadata *md, int i)
+{
+    return md->values[i];
+}
+
+static void ffmpeg_metadata_add(struct ffmpeg_metadata *md, AVDictionary *m)
+{
+    AVDictionaryEntry *tag = NULL;
+    while ((tag = av_dict_get(m, "", tag, AV_DICT_IGNORE_SUFFIX))) {
+        md->keys = realloc(md->keys, (md->len + 1) * sizeof(char *));
+        md->values = realloc(md->values, (md->len + 1) * sizeof(char *));
+        md->keys[md->len] = strdup(tag->key);
+        md->values[md->len] = strdup(tag->value);
+        md->len++;
+    }
+}
+
 struct ffmpeg_file *ffmpeg_open(const char *filename)
 {
     struct ffmpeg_file *f = ffmpeg_alloc(sizeof(struct ffmpeg_file));
@@ -158,8 +199,6 @@ struct ffmpeg_file *ffmpeg_open(const char *filename)
         return NULL;
     }
 
-    f->channels = 2;
-    f->sample_rate = 44100;
     f->sample_fmt = AV_SAMPLE_FMT_S16;
 
     return f;
@@ -193,22 +232,73 @@ struct ffmpeg_metadata *ffmpeg_metadata(struct ffmpeg_file *file)
     AVStream *s = file->format->streams[file->stream];
     struct ffmpeg_metadata *md = ffmpeg_alloc(sizeof(struct ffmpeg_metadata));
     md->duration = (int) (av_q2d(s->time_base) * s->duration);
+    md->codec = avcodec_get_name(s->codec->codec_id);
+    md->bitrate = s->codec->bit_rate;
+    if (md->bitrate == 0) {
+        // Some containers (e.g. FLAC) know the overall bitrate only.
+        md->bitrate = file->format->bit_rate;
+    }
+    md->bit_depth = s->codec->bits_per_raw_sample;
+    md->sample_rate = s->codec->sample_rate;
+    md->channels = s->codec->channels;
 
-    AVDictionary *m = file->format->metadata;
-    AVDictionaryEntry *tag = NULL;
-    while ((tag = av_dict_get(m, "", tag, AV_DICT_IGNORE_SUFFIX))) {
-        if (strcmp(tag->key, "artist") == 0) {
-            md->artist = strdup(tag->value);
-        } else if (strcmp(tag->key, "album") == 0) {
-            md->album = strdup(tag->value);
-        } else if (strcmp(tag->key, "title") == 0) {
-            md->title = strdup(tag->value);
-        } else if (strcmp(tag->key, "track") == 0) {
-            md->number = strdup(tag->value);
+    // Container level tags go first, so they take precedence over
+    // stream level ones (Ogg files keep Vorbis comments in the stream).
+    ffmpeg_metadata_add(md, file->format->metadata);
+    ffmpeg_metadata_add(md, s->metadata);
+
+    return md;
+}
+
+static const char *ffmpeg_picture_mime(enum AVCodecID id)
+{
+    switch (id) {
+    case AV_CODEC_ID_MJPEG:
+        return "image/jpeg";
+    case AV_CODEC_ID_PNG:
+        return "image/png";
+    case AV_CODEC_ID_GIF:
+        return "image/gif";
+    case AV_CODEC_ID_BMP:
+        return "image/bmp";
+    default:
+        return NULL;
+    }
+}
+
+// ffmpeg_picture finds embedded picture (ID3 APIC frame, FLAC PICTURE
+// or Vorbis METADATA_BLOCK_PICTURE), front cover is preferred.
+// Returns 0 if picture is found.
+int ffmpeg_picture(struct ffmpeg_file *file, struct ffmpeg_picture *pic)
+{
+    int found = -1;
+
+    for (int i = 0; i < file->format->nb_streams; i++) {
+        AVStream *s = file->format->streams[i];
+        if (!(s->disposition & AV_DISPOSITION_ATTACHED_PIC)
+            || s->attached_pic.size <= 0) {
+            continue;
+        }
+        const char *mime = ffmpeg_picture_mime(s->codec->codec_id);
+        if (mime == NULL) {
+            continue;
+        }
+        AVDictionaryEntry *c = av_dict_get(s->metadata, "comment", NULL, 0);
+        int front = c != NULL && strcmp(c->value, "Cover (front)") == 0;
+        if (found == 0 && !front) {
+            continue;
+        }
+
+        pic->mime = mime;
+        pic->data = s->attached_pic.data;
+        pic->size = s->attached_pic.size;
+        found = 0;
+        if (front) {
+            break;
         }
     }
 
-    return md;
+    return found;
 }
 
 int ffmpeg_open_codec(struct ffmpeg_file *file)
@@ -224,24 +314,33 @@ int ffmpeg_open_codec(struct ffmpeg_file *file)
     av_init_packet(file->pkt);
     av_packet_unref(file->pkt);
 
-    file->time = 0;
+    file->buf_pos = 0;
+    file->seek_pos = -1;
     file->frame = av_frame_alloc();
     if (!file->frame) {
         return -1;
     }
 
+    // Sample rate and channels are kept as is, only sample format
+    // is converted.
+    file->channels = file->codec->channels;
+    file->sample_rate = file->codec->sample_rate;
     file->swr = swr_alloc();
     if (!file->swr) {
         return -1;
     }
+    // Many mono and PCM streams do not set channel layout,
+    // so guess it by the number of channels.
+    int64_t layout = file->codec->channel_layout;
+    if (layout == 0) {
+        layout = av_get_default_channel_layout(file->codec->channels);
+    }
     av_opt_set_int(file->swr, "in_channel_count",
             file->codec->channels, 0);
     av_opt_set_int(file->swr, "out_channel_count",
             file->channels, 0);
-    av_opt_set_int(file->swr, "in_channel_layout",
-            file->codec->channel_layout, 0);
-    av_opt_set_int(file->swr, "out_channel_layout",
-            AV_CH_LAYOUT_STEREO, 0);
+    av_opt_set_int(file->swr, "in_channel_layout", layout, 0);
+    av_opt_set_int(file->swr, "out_channel_layout", layout, 0);
     av_opt_set_int(file->swr, "in_sample_rate",
             file->codec->sample_rate, 0);
     av_opt_set_int(file->swr, "out_sample_rate",
@@ -269,7 +368,9 @@ int ffmpeg_read(struct ffmpeg_file *file, char *buf, int len)
                 if (n < 0) {
                     ffmpeg_reset_pkt(file);
                     if (i >= 3) {
-                        return n;
+                        // Return data decoded so far, error
+                        // is returned by the next call.
+                        return wrote > 0 ? wrote : n;
                     }
                 } else {
                     break;
@@ -288,19 +389,19 @@ int ffmpeg_read(struct ffmpeg_file *file, char *buf, int len)
     return wrote;
 }
 
-int ffmpeg_seek(struct ffmpeg_file *file, int pos, int rel)
+// ffmpeg_seek seeks to the sample at pos. Stream is positioned to
+// the frame preceding pos and decoded samples before pos are dropped.
+int ffmpeg_seek(struct ffmpeg_file *file, int64_t pos)
 {
-    if (!rel && pos < 0) {
+    if (pos < 0) {
         return -1;
     }
 
     AVStream *s = file->format->streams[file->stream];
-    int64_t pos_pts = pos / av_q2d(s->time_base);
-    int pts;
-    if (rel) {
-        pts = file->time + pos_pts;
-    } else {
-        pts = s->start_time + pos_pts;
+    int64_t pts = av_rescale_q(pos, (AVRational) {1, file->sample_rate},
+            s->time_base);
+    if (s->start_time != AV_NOPTS_VALUE) {
+        pts += s->start_time;
     }
     int e = av_seek_frame(file->format, file->stream, pts,
             AVSEEK_FLAG_ANY | AVSEEK_FLAG_BACKWARD);
@@ -309,20 +410,20 @@ int ffmpeg_seek(struct ffmpeg_file *file, int pos, int rel)
     }
 
     avcodec_flush_buffers(file->codec);
-    file->time = pts;
     file->buf_len = 0;
     file->buf_offset = 0;
+    file->buf_pos = pos;
+    file->seek_pos = pos;
     ffmpeg_reset_pkt(file);
 
     return 0;
 }
 
-int ffmpeg_time(struct ffmpeg_file *file)
+// ffmpeg_position returns position of the next sample ffmpeg_read
+// returns.
+int64_t ffmpeg_position(struct ffmpeg_file *file)
 {
-    AVStream *s = file->format->streams[file->stream];
-    int ts = file->time * av_q2d(s->time_base);
-
-    return ts;
+    return file->buf_pos + file->buf_offset / (file->channels * 2);
 }
 
 int ffmpeg_channels(struct ffmpeg_file *file)
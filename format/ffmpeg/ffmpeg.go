@@ -26,38 +26,47 @@ import "C"
 import (
 	"errors"
 	"fmt"
-	"strconv"
 	"unsafe"
 
 	"github.com/vchimishuk/chub/format"
 )
 
 type metadata struct {
-	artist string
-	album  string
-	title  string
-	number int
-	length int
+	tags       map[string]string
+	length     int
+	codec      string
+	bitrate    int
+	bitDepth   int
+	sampleRate int
+	channels   int
 }
 
-func (m *metadata) Artist() string {
-	return m.artist
+func (m *metadata) Tags() map[string]string {
+	return m.tags
 }
 
-func (m *metadata) Album() string {
-	return m.album
+func (m *metadata) Length() int {
+	return m.length
 }
 
-func (m *metadata) Title() string {
-	return m.title
+func (m *metadata) Codec() string {
+	return m.codec
 }
 
-func (m *metadata) Number() int {
-	return m.number
+func (m *metadata) Bitrate() int {
+	return m.bitrate
 }
 
-func (m *metadata) Length() int {
-	return m.length
+func (m *metadata) BitDepth() int {
+	return m.bitDepth
+}
+
+func (m *metadata) SampleRate() int {
+	return m.sampleRate
+}
+
+func (m *metadata) Channels() int {
+	return m.channels
 }
 
 type decoder struct {
@@ -70,13 +79,12 @@ func newDecoder(path string) (*decoder, error) {
 
 	file := C.ffmpeg_open(p)
 	if file == nil {
-		// TODO: Handle error.
-		panic("TODO: ")
+		return nil, errors.New("failed to open file")
 	}
 	e := C.ffmpeg_open_codec(file)
 	if e != 0 {
 		C.ffmpeg_close(file)
-		return nil, errors.New("TODO:")
+		return nil, errors.New("failed to open codec")
 	}
 
 	return &decoder{file: file}, nil
@@ -95,21 +103,37 @@ func (d *decoder) Read(buf []byte) (read int, err error) {
 }
 
 func (d *decoder) Seek(pos int, rel bool) error {
-	if !rel && pos < 0 {
+	p := int64(pos) * int64(d.SampleRate())
+	if rel {
+		p += d.Position()
+		if p < 0 {
+			p = 0
+		}
+	}
+
+	return d.SeekPosition(p)
+}
+
+func (d *decoder) Time() int {
+	return int(d.Position() / int64(d.SampleRate()))
+}
+
+func (d *decoder) Position() int64 {
+	return int64(C.ffmpeg_position(d.file))
+}
+
+func (d *decoder) SeekPosition(pos int64) error {
+	if pos < 0 {
 		return errors.New("invalid seek position")
 	}
-	e := C.ffmpeg_seek(d.file, _Ctype_int(pos), _Ctype_int(btoi(rel)))
+	e := C.ffmpeg_seek(d.file, C.int64_t(pos))
 	if e < 0 {
-		return errors.New("TODO:")
+		return errors.New("seek failed")
 	}
 
 	return nil
 }
 
-func (d *decoder) Time() int {
-	return int(C.ffmpeg_time(d.file))
-}
-
 func (d *decoder) SampleRate() int {
 	return int(C.ffmpeg_sample_rate(d.file))
 }
@@ -146,33 +170,52 @@ func (f ffmpeg) Metadata(path string) (format.Metadata, error) {
 
 	file := C.ffmpeg_open(p)
 	if file == nil {
-		// TODO: Handle error.
-		panic("TODO: ")
+		return nil, errors.New("failed to open file")
 	}
 	defer C.ffmpeg_close(file)
 	md := C.ffmpeg_metadata(file)
 	defer C.ffmpeg_metadata_free(md)
 
-	n, _ := strconv.Atoi(C.GoString(md.number))
 	m := &metadata{
-		artist: C.GoString(md.artist),
-		album:  C.GoString(md.album),
-		title:  C.GoString(md.title),
-		number: n,
-		length: int(md.duration),
+		tags:       make(map[string]string, int(md.len)),
+		length:     int(md.duration),
+		codec:      C.GoString(md.codec),
+		bitrate:    int(md.bitrate) / 1000,
+		bitDepth:   int(md.bit_depth),
+		sampleRate: int(md.sample_rate),
+		channels:   int(md.channels),
+	}
+	for i := 0; i < int(md.len); i++ {
+		k := C.GoString(C.ffmpeg_metadata_key(md, C.int(i)))
+		if _, ok := m.tags[k]; !ok {
+			m.tags[k] = C.GoString(C.ffmpeg_metadata_value(md, C.int(i)))
+		}
 	}
 
 	return m, nil
 }
 
-func (f ffmpeg) Decoder(path string) (format.Decoder, error) {
-	return newDecoder(path)
-}
+func (f ffmpeg) Picture(path string) (*format.Picture, error) {
+	p := C.CString(path)
+	defer C.free(unsafe.Pointer(p))
+
+	file := C.ffmpeg_open(p)
+	if file == nil {
+		return nil, errors.New("failed to open file")
+	}
+	defer C.ffmpeg_close(file)
 
-func btoi(b bool) int {
-	if b {
-		return 1
-	} else {
-		return 0
+	var pic C.struct_ffmpeg_picture
+	if C.ffmpeg_picture(file, &pic) != 0 {
+		return nil, nil
 	}
+
+	return &format.Picture{
+		MIME: C.GoString(pic.mime),
+		Data: C.GoBytes(unsafe.Pointer(pic.data), pic.size),
+	}, nil
+}
+
+func (f ffmpeg) Decoder(path string) (format.Decoder, error) {
+	return newDecoder(path)
 }
@@ -22,11 +22,24 @@
 #include <libswresample/swresample.h>
 
 struct ffmpeg_metadata {
-    char *artist;
-    char *album;
-    char *title;
-    char *number;
     int duration;
+    const char *codec;
+    /* Bitrate in bits per second. */
+    int bitrate;
+    int bit_depth;
+    int sample_rate;
+    int channels;
+    /* Number of tags and their names and values. */
+    int len;
+    char **keys;
+    char **values;
+};
+
+struct ffmpeg_picture {
+    const char *mime;
+    /* Picture data is valid till the file is closed. */
+    uint8_t *data;
+    int size;
 };
 
 struct ffmpeg_file {
@@ -39,14 +52,17 @@ struct ffmpeg_file {
     int channels;
     int sample_rate;
     enum AVSampleFormat sample_fmt;
-    /* Current decoding position in seconds. */
-    int time;
     uint8_t **buf;
     int buf_nsamples;
     int buf_len;
     int buf_offset;
     /* Number of bytes decoded from the current packet. */
     int pkt_decoded;
+    /* Position of the first sample in buf in samples. */
+    int64_t buf_pos;
+    /* Samples before this position are dropped after seek,
+       -1 if there is no seek in progress. */
+    int64_t seek_pos;
 };
 
 void ffmpeg_init();
@@ -54,11 +70,14 @@ char *ffmpeg_last_error();
 struct ffmpeg_file *ffmpeg_open(const char *filename);
 void ffmpeg_close(struct ffmpeg_file *file);
 struct ffmpeg_metadata *ffmpeg_metadata(struct ffmpeg_file *file);
+int ffmpeg_picture(struct ffmpeg_file *file, struct ffmpeg_picture *pic);
 int ffmpeg_open_codec(struct ffmpeg_file *file);
 int ffmpeg_read(struct ffmpeg_file *file, char *buf, int len);
-int ffmpeg_seek(struct ffmpeg_file *file, int pos, int rel);
+int ffmpeg_seek(struct ffmpeg_file *file, int64_t pos);
 void ffmpeg_metadata_free(struct ffmpeg_metadata *metadata);
-int ffmpeg_time(struct ffmpeg_file *file);
+char *ffmpeg_metadata_key(struct ffmpeg_metadata *metadata, int i);
+char *ffmpeg_metadata_value(struct ffmpeg_metadata *metadata, int i);
+int64_t ffmpeg_position(struct ffmpeg_file *file);
 int ffmpeg_channels(struct ffmpeg_file *file);
 int ffmpeg_sample_rate(struct ffmpeg_file *file);
 
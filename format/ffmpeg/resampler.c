@@ -0,0 +1,144 @@
+// Copyright 2019 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+#include <stdlib.h>
+#include <libavutil/channel_layout.h>
+#include <libavutil/mathematics.h>
+#include <libavutil/mem.h>
+#include "resampler.h"
+
+// ffmpeg_resampler_new returns resampler which converts signed 16 bit
+// interleaved PCM data to the given sample rate and number of channels.
+struct ffmpeg_resampler *ffmpeg_resampler_new(int rate, int channels)
+{
+    struct ffmpeg_resampler *r = calloc(1, sizeof(struct ffmpeg_resampler));
+    if (r == NULL) {
+        return NULL;
+    }
+    r->rate = rate;
+    r->channels = channels;
+
+    return r;
+}
+
+static int ffmpeg_resampler_setup(struct ffmpeg_resampler *r, int rate,
+        int channels)
+{
+    if (r->swr != NULL && r->in_rate == rate && r->in_channels == channels) {
+        return 0;
+    }
+    swr_free(&r->swr);
+    r->swr = swr_alloc_set_opts(NULL,
+            av_get_default_channel_layout(r->channels), AV_SAMPLE_FMT_S16,
+            r->rate,
+            av_get_default_channel_layout(channels), AV_SAMPLE_FMT_S16,
+            rate, 0, NULL);
+    if (r->swr == NULL || swr_init(r->swr) < 0) {
+        swr_free(&r->swr);
+        return -1;
+    }
+    r->in_rate = rate;
+    r->in_channels = channels;
+
+    return 0;
+}
+
+// ffmpeg_resampler_alloc grows output buffer to hold nsamples samples.
+static int ffmpeg_resampler_alloc(struct ffmpeg_resampler *r, int nsamples)
+{
+    if (r->out_nsamples >= nsamples) {
+        return 0;
+    }
+    av_freep(&r->out);
+    if (av_samples_alloc(&r->out, NULL, r->channels, nsamples,
+                AV_SAMPLE_FMT_S16, 1) < 0) {
+        r->out_nsamples = 0;
+        return -1;
+    }
+    r->out_nsamples = nsamples;
+
+    return 0;
+}
+
+// ffmpeg_resampler_convert converts PCM data of the given format.
+// Converted data is stored in the resampler's out buffer and its
+// length is returned. Some samples can be delayed till the next call.
+// Negative value is returned on error.
+int ffmpeg_resampler_convert(struct ffmpeg_resampler *r, char *buf, int len,
+        int rate, int channels)
+{
+    if (ffmpeg_resampler_setup(r, rate, channels) < 0) {
+        return -1;
+    }
+
+    int in_nsamples = len / (2 * channels);
+    int nsamples = av_rescale_rnd(swr_get_delay(r->swr, rate) + in_nsamples,
+            r->rate, rate, AV_ROUND_UP);
+    if (ffmpeg_resampler_alloc(r, nsamples) < 0) {
+        return -1;
+    }
+
+    const uint8_t *in = (const uint8_t *) buf;
+    int n = swr_convert(r->swr, &r->out, nsamples, &in, in_nsamples);
+    if (n < 0) {
+        return -1;
+    }
+    r->out_len = n * 2 * r->channels;
+
+    return r->out_len;
+}
+
+// ffmpeg_resampler_flush converts samples delayed by the previous
+// convert calls. Converted data is stored in the resampler's out buffer
+// and its length is returned. Negative value is returned on error.
+int ffmpeg_resampler_flush(struct ffmpeg_resampler *r)
+{
+    r->out_len = 0;
+    if (r->swr == NULL) {
+        return 0;
+    }
+
+    int nsamples = av_rescale_rnd(swr_get_delay(r->swr, r->in_rate),
+            r->rate, r->in_rate, AV_ROUND_UP);
+    if (nsamples == 0) {
+        return 0;
+    }
+    if (ffmpeg_resampler_alloc(r, nsamples) < 0) {
+        return -1;
+    }
+
+    int n = swr_convert(r->swr, &r->out, nsamples, NULL, 0);
+    if (n < 0) {
+        return -1;
+    }
+    r->out_len = n * 2 * r->channels;
+
+    return r->out_len;
+}
+
+// ffmpeg_resampler_reset drops delayed samples.
+void ffmpeg_resampler_reset(struct ffmpeg_resampler *r)
+{
+    swr_free(&r->swr);
+}
+
+void ffmpeg_resampler_free(struct ffmpeg_resampler *r)
+{
+    swr_free(&r->swr);
+    av_freep(&r->out);
+    free(r);
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package ffmpeg
+
+// #include <stdlib.h>
+// #include "resampler.h"
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/vchimishuk/chub/format"
+)
+
+type resampler struct {
+	r *C.struct_ffmpeg_resampler
+}
+
+// NewResampler returns resampler to the given sample rate and number
+// of channels. Channels are up or downmixed with the default channel
+// layouts.
+func NewResampler(rate int, channels int) (format.Resampler, error) {
+	if rate <= 0 || channels <= 0 {
+		return nil, errors.New("invalid PCM format")
+	}
+	r := C.ffmpeg_resampler_new(C.int(rate), C.int(channels))
+	if r == nil {
+		return nil, errors.New("failed to create resampler")
+	}
+
+	return &resampler{r: r}, nil
+}
+
+func (r *resampler) Convert(buf []byte, rate int, channels int) ([]byte, error) {
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	p := (*C.char)(unsafe.Pointer(&buf[0]))
+	n := C.ffmpeg_resampler_convert(r.r, p, C.int(len(buf)),
+		C.int(rate), C.int(channels))
+	if n < 0 {
+		return nil, errors.New("failed to resample")
+	}
+
+	return C.GoBytes(unsafe.Pointer(r.r.out), n), nil
+}
+
+func (r *resampler) Flush() ([]byte, error) {
+	n := C.ffmpeg_resampler_flush(r.r)
+	if n < 0 {
+		return nil, errors.New("failed to resample")
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	return C.GoBytes(unsafe.Pointer(r.r.out), n), nil
+}
+
+func (r *resampler) Reset() {
+	C.ffmpeg_resampler_reset(r.r)
+}
+
+func (r *resampler) Close() {
+	C.ffmpeg_resampler_free(r.r)
+}
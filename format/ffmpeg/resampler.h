@@ -0,0 +1,45 @@
+// Copyright 2019 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+#ifndef CHUB_FFMPEG_RESAMPLER_H
+#define CHUB_FFMPEG_RESAMPLER_H
+
+#include <libswresample/swresample.h>
+
+struct ffmpeg_resampler {
+    SwrContext *swr;
+    /* Output format. */
+    int rate;
+    int channels;
+    /* Input format swr is configured for. */
+    int in_rate;
+    int in_channels;
+    /* Converted signed 16 bit interleaved samples. */
+    uint8_t *out;
+    int out_len;
+    /* Output buffer size in samples. */
+    int out_nsamples;
+};
+
+struct ffmpeg_resampler *ffmpeg_resampler_new(int rate, int channels);
+int ffmpeg_resampler_convert(struct ffmpeg_resampler *r, char *buf, int len,
+        int rate, int channels);
+int ffmpeg_resampler_flush(struct ffmpeg_resampler *r);
+void ffmpeg_resampler_reset(struct ffmpeg_resampler *r);
+void ffmpeg_resampler_free(struct ffmpeg_resampler *r);
+
+#endif // CHUB_FFMPEG_RESAMPLER_H
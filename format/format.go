@@ -27,11 +27,23 @@ import (
 var ErrNotSupported = errors.New("not supported audio format")
 
 type Metadata interface {
-	Artist() string
-	Album() string
-	Title() string
-	Number() int
+	// Tags returns all tags found in the file. Keys are tag names
+	// as they are stored in the file, e.g. "artist", "TRACKNUMBER"
+	// or "TPE1".
+	Tags() map[string]string
+	// Length returns track length in seconds.
 	Length() int
+	// Codec returns audio codec name, e.g. "mp3" or "flac".
+	Codec() string
+	// Bitrate returns average bitrate in kbit/s.
+	Bitrate() int
+	// BitDepth returns number of bits per sample of the source
+	// stream or 0 if it is not applicable (lossy codecs).
+	BitDepth() int
+	// SampleRate returns sample rate of the source stream.
+	SampleRate() int
+	// Channels returns number of channels in the source stream.
+	Channels() int
 }
 
 // Decoder interface represents audio decoder for the particular audio format.
@@ -44,6 +56,13 @@ type Decoder interface {
 	Seek(pos int, rel bool) error
 	// Time returns current decoded position in seconds.
 	Time() int
+	// Position returns current decoded position in samples
+	// (per channel) from the beginning of the stream, that is
+	// the position of the sample the next Read starts with.
+	Position() int64
+	// SeekPosition sets new position in samples to start decoding
+	// from. Position is sample accurate.
+	SeekPosition(pos int64) error
 	// SampleRate returns sample rate of decoded stream.
 	SampleRate() int
 	// Channels returns number of channels in decoded stream.
@@ -52,12 +71,79 @@ type Decoder interface {
 	Close()
 }
 
+// Resampler converts PCM data to the fixed sample rate and number
+// of channels.
+type Resampler interface {
+	// Convert converts signed 16 bit little endian PCM data of the
+	// given format. Some samples can be kept by resampler till
+	// the next call.
+	Convert(buf []byte, rate int, channels int) ([]byte, error)
+	// Flush returns kept samples converted, it is called at the end
+	// of the stream.
+	Flush() ([]byte, error)
+	// Reset drops kept samples, e.g. after seek.
+	Reset()
+	// Close releases resampler resources.
+	Close()
+}
+
+// Encoder encodes PCM data into a continuous audio stream.
+type Encoder interface {
+	// MIME returns stream MIME type, e.g. "audio/mpeg".
+	MIME() string
+	// Header returns data every stream has to start with, e.g.
+	// container header. Header can be empty.
+	Header() []byte
+	// Encode encodes signed 16 bit little endian PCM data of the given
+	// format and returns encoded stream data. Returned data can be
+	// empty if encoder needs more input to produce the next frame.
+	Encode(buf []byte, rate int, channels int) ([]byte, error)
+	// Close releases encoder resources.
+	Close()
+}
+
+// Picture is an image embedded into audio file.
+type Picture struct {
+	// MIME type of the image, e.g. "image/jpeg".
+	MIME string
+	Data []byte
+}
+
 type Format interface {
 	Extensions() []string
 	Metadata(path string) (Metadata, error)
+	// Picture returns embedded cover picture or nil if file has no
+	// pictures.
+	Picture(path string) (*Picture, error)
 	Decoder(path string) (Decoder, error)
 }
 
+// subset is a format restricted to some of its extensions.
+type subset struct {
+	Format
+	exts []string
+}
+
+func (s *subset) Extensions() []string {
+	return s.exts
+}
+
+// Subset returns format which supports only those of f's extensions
+// which are present in the exts list.
+func Subset(f Format, exts []string) Format {
+	var es []string
+	for _, e := range f.Extensions() {
+		for _, ee := range exts {
+			if strings.ToLower(ee) == e {
+				es = append(es, e)
+				break
+			}
+		}
+	}
+
+	return &subset{Format: f, exts: es}
+}
+
 var formats map[string]Format = make(map[string]Format)
 
 func Register(f Format) {
@@ -75,6 +161,15 @@ func GetMetadata(path string) (Metadata, error) {
 	return f.Metadata(path)
 }
 
+func GetPicture(path string) (*Picture, error) {
+	f, ok := formats[ext(path)]
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	return f.Picture(path)
+}
+
 func GetDecoder(path string) (Decoder, error) {
 	for _, f := range formats {
 		d, err := f.Decoder(path)
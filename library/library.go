@@ -0,0 +1,730 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+// library package implements music library index. Index keeps all VFS
+// tracks with their tags, so tracks can be searched and listed without
+// scanning the filesystem.
+//
+// Index is stored in a text file with a header line followed by one
+// track per line. Line fields are tab separated: VFS path (including :N
+// CUE track suffix), length, part flag, CUE track number, start and end
+// positions, codec, bitrate, bit depth, sample rate and channels
+// followed by tag name and value pairs (see vfs.Tag.Map). Path, codec,
+// tag names and values are quoted with Go syntax. Empty codec means
+// audio properties are unknown.
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/vchimishuk/chub/logger"
+	"github.com/vchimishuk/chub/vfs"
+)
+
+const (
+	fileHeader = "#CHUB-LIBRARY 5"
+	// Number of fields in the track line preceding tags.
+	numFields = 13
+)
+
+// Track fields Find can match.
+const (
+	FieldArtist      = "artist"
+	FieldAlbumArtist = "albumartist"
+	FieldAlbum       = "album"
+	FieldTitle       = "title"
+	FieldComposer    = "composer"
+	FieldGenre       = "genre"
+	FieldDate        = "date"
+)
+
+// Album describes album found in the library.
+type Album struct {
+	Artist string
+	Album  string
+	// Number of tracks.
+	Tracks int
+	// Total length in seconds.
+	Length int
+}
+
+// Change describes library contents change.
+type Change struct {
+	// New and changed tracks.
+	Added []*vfs.Track
+	// Paths of removed and changed tracks.
+	Removed []string
+}
+
+type Library struct {
+	// Index file path.
+	file string
+	// Mutex guards changeHandlers field.
+	handlersMu sync.Mutex
+	// Called on every library change.
+	changeHandlers []func(*Change)
+	// Mutex guards pending field.
+	pendingMu sync.Mutex
+	// Changes waiting to be passed to the handlers.
+	pending []*Change
+	// Mutex serializes flush calls, so handlers receive changes
+	// in order they happen.
+	flushMu sync.Mutex
+	// Mutex serializes Load, Update and Refresh calls.
+	updateMu sync.Mutex
+	// Mutex guards tracks and words fields.
+	mu     sync.RWMutex
+	tracks []*vfs.Track
+	// Full-text index: positions in tracks list for every word
+	// met in the track tags and path.
+	words map[string][]int
+	// Mutex guards fingerprints field.
+	fpMu sync.Mutex
+	// Fingerprints computed by Resolve by track path.
+	fingerprints map[string]string
+}
+
+// New returns empty library which is stored in the given file.
+func New(file string) *Library {
+	return &Library{
+		file:         file,
+		words:        make(map[string][]int),
+		fingerprints: make(map[string]string),
+	}
+}
+
+// AddChangeHandler adds handler to be called after every library
+// change. Handlers are called one by one in the order they were added.
+func (l *Library) AddChangeHandler(h func(*Change)) {
+	l.handlersMu.Lock()
+	l.changeHandlers = append(l.changeHandlers, h)
+	l.handlersMu.Unlock()
+}
+
+// Load reads index from the file. Tracks which cannot be found in VFS
+// anymore are skipped.
+func (l *Library) Load() error {
+	l.updateMu.Lock()
+	defer l.updateMu.Unlock()
+
+	f, err := os.Open(l.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tracks, err := decode(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", l.file, err)
+	}
+	l.set(tracks)
+
+	return nil
+}
+
+// Update rebuilds index walking the whole VFS tree and saves it.
+func (l *Library) Update() error {
+	l.updateMu.Lock()
+	defer l.flush()
+	defer l.updateMu.Unlock()
+
+	root, err := vfs.NewPath("/")
+	if err != nil {
+		return err
+	}
+	tracks := walk(root)
+	ch := diff(l.tracks, tracks)
+	l.set(tracks)
+	err = writeFile(l.file, encode(tracks))
+	if err != nil {
+		return err
+	}
+	l.changed(ch)
+
+	return nil
+}
+
+// Refresh rescans tracks of the VFS directory and updates the index
+// if anything has been changed. Subdirectories are rescanned too if
+// recursive is true. Tracks of the removed directory are removed.
+func (l *Library) Refresh(dir string, recursive bool) error {
+	l.updateMu.Lock()
+	defer l.flush()
+	defer l.updateMu.Unlock()
+
+	var fresh []*vfs.Track
+	p, err := vfs.NewPath(dir)
+	// Error means directory does not exist anymore.
+	if err == nil && p.IsDir() {
+		if recursive {
+			fresh = walk(p)
+		} else {
+			entries, err := p.List()
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if !e.IsDir() {
+					fresh = append(fresh, e.Track())
+				}
+			}
+		}
+	}
+
+	// Fresh tracks replace old ones keeping their place in the list.
+	var old []*vfs.Track
+	kept := make([]*vfs.Track, 0, len(l.tracks))
+	pos := -1
+	for _, t := range l.tracks {
+		if inDir(t, dir, recursive) {
+			if pos == -1 {
+				pos = len(kept)
+			}
+			old = append(old, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+	ch := diff(old, fresh)
+	if len(ch.Added) == 0 && len(ch.Removed) == 0 {
+		return nil
+	}
+	if pos == -1 {
+		pos = len(kept)
+	}
+	tracks := make([]*vfs.Track, 0, len(kept)+len(fresh))
+	tracks = append(tracks, kept[:pos]...)
+	tracks = append(tracks, fresh...)
+	tracks = append(tracks, kept[pos:]...)
+
+	l.set(tracks)
+	err = writeFile(l.file, encode(tracks))
+	if err != nil {
+		return err
+	}
+	l.changed(ch)
+
+	return nil
+}
+
+// Len returns number of tracks in the library.
+func (l *Library) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return len(l.tracks)
+}
+
+// Resolve returns library track with the given fingerprint (see
+// vfs.Track.Fingerprint) and length or nil if there is no such track.
+// It is used to find moved and renamed tracks. Fingerprints are
+// computed only for tracks of the given length.
+func (l *Library) Resolve(id string, length int) *vfs.Track {
+	var candidates []*vfs.Track
+	l.mu.RLock()
+	for _, t := range l.tracks {
+		if t.Length == length {
+			candidates = append(candidates, t)
+		}
+	}
+	l.mu.RUnlock()
+
+	for _, t := range candidates {
+		p := t.Path.String()
+		l.fpMu.Lock()
+		fp, ok := l.fingerprints[p]
+		l.fpMu.Unlock()
+		if !ok {
+			var err error
+			fp, err = t.Fingerprint()
+			if err != nil {
+				logger.Warning("library: %s: %s", p, err)
+				continue
+			}
+			l.fpMu.Lock()
+			l.fingerprints[p] = fp
+			l.fpMu.Unlock()
+		}
+		if fp == id {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Search returns tracks which tags or path contain words starting
+// with every word of the query. Case is ignored.
+func (l *Library) Search(query string) []*vfs.Track {
+	terms := splitWords(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// Number of matched terms for every track.
+	matches := make(map[int]int)
+	for _, t := range terms {
+		found := make(map[int]bool)
+		for w, positions := range l.words {
+			if strings.HasPrefix(w, t) {
+				for _, p := range positions {
+					found[p] = true
+				}
+			}
+		}
+		for p := range found {
+			matches[p]++
+		}
+	}
+
+	positions := make([]int, 0, len(matches))
+	for p, n := range matches {
+		if n == len(terms) {
+			positions = append(positions, p)
+		}
+	}
+	sort.Ints(positions)
+
+	tracks := make([]*vfs.Track, 0, len(positions))
+	for _, p := range positions {
+		tracks = append(tracks, l.tracks[p])
+	}
+
+	return tracks
+}
+
+// Find returns tracks which field (one of Field constants) equals
+// to the value ignoring case.
+func (l *Library) Find(field string, value string) ([]*vfs.Track, error) {
+	var get func(t *vfs.Tag) string
+	switch field {
+	case FieldArtist:
+		get = func(t *vfs.Tag) string { return t.Artist }
+	case FieldAlbum:
+		get = func(t *vfs.Tag) string { return t.Album }
+	case FieldTitle:
+		get = func(t *vfs.Tag) string { return t.Title }
+	case FieldAlbumArtist:
+		get = func(t *vfs.Tag) string { return t.AlbumArtist }
+	case FieldComposer:
+		get = func(t *vfs.Tag) string { return t.Composer }
+	case FieldGenre:
+		get = func(t *vfs.Tag) string { return t.Genre }
+	case FieldDate:
+		get = func(t *vfs.Tag) string { return t.Date }
+	default:
+		return nil, errors.New("invalid field")
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var tracks []*vfs.Track
+	for _, t := range l.tracks {
+		if t.Tag != nil && strings.EqualFold(get(t.Tag), value) {
+			tracks = append(tracks, t)
+		}
+	}
+
+	return tracks, nil
+}
+
+// Artists returns sorted list of all artists.
+func (l *Library) Artists() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var artists []string
+	for _, t := range l.tracks {
+		if t.Tag != nil && t.Tag.Artist != "" && !seen[t.Tag.Artist] {
+			seen[t.Tag.Artist] = true
+			artists = append(artists, t.Tag.Artist)
+		}
+	}
+	sort.Strings(artists)
+
+	return artists
+}
+
+// Albums returns list of all albums sorted by artist and album name.
+// Album artist is used as the album's artist if it is set, so
+// compilation tracks are not split into separate albums.
+func (l *Library) Albums() []*Album {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	type key struct {
+		artist string
+		album  string
+	}
+	index := make(map[key]*Album)
+	var albums []*Album
+	for _, t := range l.tracks {
+		if t.Tag == nil || t.Tag.Album == "" {
+			continue
+		}
+		k := key{t.Tag.AlbumArtist, t.Tag.Album}
+		if k.artist == "" {
+			k.artist = t.Tag.Artist
+		}
+		a, ok := index[k]
+		if !ok {
+			a = &Album{Artist: k.artist, Album: k.album}
+			index[k] = a
+			albums = append(albums, a)
+		}
+		a.Tracks++
+		a.Length += t.Length
+	}
+	sort.Slice(albums, func(i, j int) bool {
+		if albums[i].Artist != albums[j].Artist {
+			return albums[i].Artist < albums[j].Artist
+		}
+		return albums[i].Album < albums[j].Album
+	})
+
+	return albums
+}
+
+// changed queues change for the handlers. Changes are queued while
+// updateMu is held, so they are in the same order as updates, and
+// passed to the handlers by flush after the mutex is released, so
+// a slow handler can't block updates.
+func (l *Library) changed(ch *Change) {
+	// Fingerprints of changed files are not valid anymore.
+	l.fpMu.Lock()
+	for _, p := range ch.Removed {
+		delete(l.fingerprints, p)
+	}
+	l.fpMu.Unlock()
+
+	if len(ch.Added) == 0 && len(ch.Removed) == 0 {
+		return
+	}
+	l.pendingMu.Lock()
+	l.pending = append(l.pending, ch)
+	l.pendingMu.Unlock()
+}
+
+// flush passes pending changes to the handlers.
+func (l *Library) flush() {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+
+	l.pendingMu.Lock()
+	changes := l.pending
+	l.pending = nil
+	l.pendingMu.Unlock()
+	l.handlersMu.Lock()
+	handlers := l.changeHandlers
+	l.handlersMu.Unlock()
+	for _, ch := range changes {
+		for _, h := range handlers {
+			h(ch)
+		}
+	}
+}
+
+// set replaces library contents with the given tracks.
+func (l *Library) set(tracks []*vfs.Track) {
+	words := make(map[string][]int)
+	for i, t := range tracks {
+		for _, w := range trackWords(t) {
+			ps := words[w]
+			// Track words are processed in a row, so duplicate
+			// can be only the last one.
+			if len(ps) == 0 || ps[len(ps)-1] != i {
+				words[w] = append(ps, i)
+			}
+		}
+	}
+
+	l.mu.Lock()
+	l.tracks = tracks
+	l.words = words
+	l.mu.Unlock()
+}
+
+// walk returns all tracks found in the directory and its
+// subdirectories. Unreadable directories are skipped.
+func walk(dir *vfs.Path) []*vfs.Track {
+	entries, err := dir.List()
+	if err != nil {
+		logger.Warning("library: %s: %s", dir, err)
+		return nil
+	}
+
+	var tracks []*vfs.Track
+	for _, e := range entries {
+		if e.IsDir() {
+			tracks = append(tracks, walk(e.Dir().Path)...)
+		} else {
+			tracks = append(tracks, e.Track())
+		}
+	}
+
+	return tracks
+}
+
+// diff returns change between old and new tracks lists.
+func diff(old []*vfs.Track, fresh []*vfs.Track) *Change {
+	ch := &Change{}
+	index := make(map[string]*vfs.Track, len(old))
+	for _, t := range old {
+		index[t.Path.String()] = t
+	}
+	for _, t := range fresh {
+		p := t.Path.String()
+		o, ok := index[p]
+		if !ok || !sameTrack(o, t) {
+			ch.Added = append(ch.Added, t)
+		}
+		if ok {
+			delete(index, p)
+			if !sameTrack(o, t) {
+				ch.Removed = append(ch.Removed, p)
+			}
+		}
+	}
+	for _, t := range old {
+		if _, ok := index[t.Path.String()]; ok {
+			ch.Removed = append(ch.Removed, t.Path.String())
+		}
+	}
+
+	return ch
+}
+
+func sameTrack(a *vfs.Track, b *vfs.Track) bool {
+	if a.Length != b.Length || a.Part != b.Part || a.Number != b.Number ||
+		a.Start != b.Start || a.End != b.End ||
+		a.Pregap != b.Pregap || a.Postgap != b.Postgap {
+		return false
+	}
+	if (a.Properties == nil) != (b.Properties == nil) ||
+		a.Properties != nil && *a.Properties != *b.Properties {
+		return false
+	}
+	if a.Tag == nil || b.Tag == nil {
+		return a.Tag == b.Tag
+	}
+
+	return a.Tag.Equal(b.Tag)
+}
+
+// inDir returns true if track is placed in the VFS directory or in one
+// of its subdirectories if recursive is true.
+func inDir(t *vfs.Track, dir string, recursive bool) bool {
+	parent := path.Dir(t.Path.Val())
+	if parent == dir {
+		return true
+	}
+	if !recursive {
+		return false
+	}
+	if dir == "/" {
+		return true
+	}
+
+	return strings.HasPrefix(parent, dir+"/")
+}
+
+func trackWords(t *vfs.Track) []string {
+	words := splitWords(t.Path.Val())
+	if t.Tag != nil {
+		words = append(words, splitWords(t.Tag.Artist)...)
+		words = append(words, splitWords(t.Tag.Album)...)
+		words = append(words, splitWords(t.Tag.Title)...)
+		words = append(words, splitWords(t.Tag.AlbumArtist)...)
+		words = append(words, splitWords(t.Tag.Composer)...)
+		words = append(words, splitWords(t.Tag.Genre)...)
+	}
+
+	return words
+}
+
+// splitWords returns lower cased words of the string.
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func encode(tracks []*vfs.Track) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(fileHeader + "\n")
+	for _, t := range tracks {
+		props := t.Properties
+		if props == nil {
+			props = &vfs.Properties{}
+		}
+		fmt.Fprintf(&b, "%s\t%d\t%t\t%d\t%d\t%d\t%d\t%d\t%s\t%d\t%d\t%d\t%d",
+			strconv.Quote(t.Path.String()), t.Length, t.Part,
+			t.Number, t.Start, t.End, t.Pregap, t.Postgap,
+			strconv.Quote(props.Codec),
+			props.Bitrate, props.BitDepth, props.SampleRate,
+			props.Channels)
+		if t.Tag != nil {
+			tags := t.Tag.Map()
+			names := make([]string, 0, len(tags))
+			for n := range tags {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				fmt.Fprintf(&b, "\t%s\t%s", strconv.Quote(n),
+					strconv.Quote(tags[n]))
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	return b.Bytes()
+}
+
+func decode(r io.Reader) ([]*vfs.Track, error) {
+	var tracks []*vfs.Track
+
+	s := bufio.NewScanner(r)
+	if !s.Scan() || s.Text() != fileHeader {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("invalid header")
+	}
+	for n := 2; s.Scan(); n++ {
+		t, err := decodeTrack(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s", n, err)
+		}
+		if t != nil {
+			tracks = append(tracks, t)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return tracks, nil
+}
+
+// decodeTrack parses track line. Nil track is returned if track's
+// file does not exist anymore.
+func decodeTrack(line string) (*vfs.Track, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < numFields || (len(fields)-numFields)%2 != 0 {
+		return nil, errors.New("invalid fields number")
+	}
+
+	strs := make([]string, 0, len(fields)-numFields+2)
+	for _, f := range append([]string{fields[0], fields[8]}, fields[numFields:]...) {
+		s, err := strconv.Unquote(f)
+		if err != nil {
+			return nil, errors.New("invalid string")
+		}
+		strs = append(strs, s)
+	}
+	tags := make(map[string]string)
+	for i := 2; i < len(strs); i += 2 {
+		tags[strs[i]] = strs[i+1]
+	}
+	var ints [10]int
+	for i, f := range []string{fields[1], fields[3], fields[4], fields[5],
+		fields[6], fields[7], fields[9], fields[10], fields[11],
+		fields[12]} {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, errors.New("invalid integer")
+		}
+		ints[i] = n
+	}
+	part, err := strconv.ParseBool(fields[2])
+	if err != nil {
+		return nil, errors.New("invalid boolean")
+	}
+
+	p, err := vfs.NewPath(strs[0])
+	if err != nil {
+		logger.Debug("library: %s: %s", strs[0], err)
+		return nil, nil
+	}
+	var props *vfs.Properties
+	if strs[1] != "" {
+		props = &vfs.Properties{
+			Codec:      strs[1],
+			Bitrate:    ints[6],
+			BitDepth:   ints[7],
+			SampleRate: ints[8],
+			Channels:   ints[9],
+		}
+	}
+
+	return &vfs.Track{
+		Path:       p,
+		Length:     ints[0],
+		Part:       part,
+		Number:     ints[1],
+		Start:      ints[2],
+		End:        ints[3],
+		Pregap:     ints[4],
+		Postgap:    ints[5],
+		Tag:        vfs.NewTag(tags),
+		Properties: props,
+	}, nil
+}
+
+// writeFile atomically replaces file with the given data.
+func writeFile(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file),
+		"."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
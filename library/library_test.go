@@ -0,0 +1,191 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package library
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vchimishuk/chub/vfs"
+)
+
+func testTracks(t *testing.T, dir string) []*vfs.Track {
+	tags := []*vfs.Tag{
+		{Artist: "Doro", Album: "Fight", Title: "Always Live to Win", Number: 1},
+		{Artist: "Doro", Album: "Fight", Title: "Fight", Number: 2},
+		{Artist: "Warlock", Album: "Triumph and Agony", Title: "All We Are", Number: 1},
+	}
+	var tracks []*vfs.Track
+	for i, tag := range tags {
+		name := tag.Title + ".mp3"
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := vfs.NewPath("/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracks = append(tracks, &vfs.Track{Path: p, Tag: tag, Length: 100 + i})
+	}
+
+	return tracks
+}
+
+func TestLibrary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = vfs.SetRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := New(filepath.Join(dir, "library"))
+	l.set(testTracks(t, dir))
+
+	tracks := l.Search("doro FIG")
+	if len(tracks) != 2 {
+		t.Fatalf("2 tracks expected but %d got", len(tracks))
+	}
+	tracks = l.Search("agony all")
+	if len(tracks) != 1 || tracks[0].Tag.Title != "All We Are" {
+		t.Fatal()
+	}
+	if len(l.Search("doro agony")) != 0 {
+		t.Fatal()
+	}
+
+	tracks, err = l.Find(FieldArtist, "doro")
+	if err != nil || len(tracks) != 2 {
+		t.Fatal()
+	}
+	if _, err := l.Find("year", "1987"); err == nil {
+		t.Fatal()
+	}
+
+	albums := l.Albums()
+	if len(albums) != 2 || *albums[0] != (Album{"Doro", "Fight", 2, 201}) ||
+		albums[1].Album != "Triumph and Agony" {
+		t.Fatal()
+	}
+	artists := l.Artists()
+	if len(artists) != 2 || artists[0] != "Doro" || artists[1] != "Warlock" {
+		t.Fatal()
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = vfs.SetRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracks := testTracks(t, dir)
+	tracks[1].Tag.Title = "Tab\tand \"quotes\""
+	tracks[1].Tag.Genre = "Heavy Metal"
+	tracks[1].Tag.DiscNumber = 2
+	tracks[1].Tag.Extra = map[string]string{"mood": "loud"}
+	tracks[1].Pregap = 150
+	tracks[1].Properties = &vfs.Properties{Codec: "flac", Bitrate: 2116,
+		BitDepth: 24, SampleRate: 96000, Channels: 2}
+	data := encode(tracks)
+	// Removed files are skipped on load.
+	os.Remove(tracks[2].Path.File())
+
+	decoded, err := decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 {
+		t.Fatalf("2 tracks expected but %d got", len(decoded))
+	}
+	for i, d := range decoded {
+		e := tracks[i]
+		if d.Path.String() != e.Path.String() || d.Length != e.Length ||
+			d.Pregap != e.Pregap ||
+			!d.Tag.Equal(e.Tag) ||
+			(d.Properties == nil) != (e.Properties == nil) ||
+			d.Properties != nil && *d.Properties != *e.Properties {
+			t.Fatalf("%v expected but %v got", e, d)
+		}
+	}
+
+	_, err = decode(bytes.NewReader([]byte(fileHeader + "\n\"/foo.mp3\"\t1\n")))
+	if err == nil {
+		t.Fatal()
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = vfs.SetRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := New(filepath.Join(dir, "library"))
+	tracks := testTracks(t, dir)
+	l.set(tracks)
+	var changes []*Change
+	l.AddChangeHandler(func(ch *Change) {
+		// Handlers must not block updates.
+		if !l.updateMu.TryLock() {
+			t.Fatal("handler is called during update")
+		}
+		l.updateMu.Unlock()
+		changes = append(changes, ch)
+	})
+
+	err = l.Refresh("/sub", true)
+	if err != nil || len(changes) != 0 || l.Len() != 3 {
+		t.Fatal()
+	}
+	// Files of unsupported format are not tracks.
+	err = l.Refresh("/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || len(changes[0].Added) != 0 ||
+		len(changes[0].Removed) != 3 || l.Len() != 0 {
+		t.Fatal()
+	}
+
+	retagged := *tracks[1]
+	retagged.Tag = &vfs.Tag{Artist: "Doro", Title: "Fight"}
+	ch := diff(tracks[:2], []*vfs.Track{&retagged})
+	if len(ch.Added) != 1 || ch.Added[0] != &retagged ||
+		len(ch.Removed) != 2 || ch.Removed[0] != tracks[1].Path.String() ||
+		ch.Removed[1] != tracks[0].Path.String() {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package library
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/vchimishuk/chub/logger"
+	"github.com/vchimishuk/chub/vfs"
+)
+
+const (
+	// Library is refreshed after there were no filesystem changes
+	// during watchDelay, but not later than maxWatchDelay after
+	// the first change.
+	watchDelay    = 2 * time.Second
+	maxWatchDelay = 30 * time.Second
+	watchMask     = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE |
+		syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+		syscall.IN_ONLYDIR
+)
+
+// inotify event.
+type event struct {
+	wd   int
+	mask uint32
+	name string
+}
+
+// Watcher monitors VFS directory tree with inotify and refreshes
+// library when files are added, removed, renamed or changed.
+type Watcher struct {
+	lib  *Library
+	fd   int
+	file *os.File
+	// Filesystem directory VFS root points to.
+	root string
+	// VFS directory path for every watch descriptor.
+	// Used by worker goroutine only after start.
+	dirs map[int]string
+	// Closed when worker is finished.
+	done chan struct{}
+}
+
+// NewWatcher starts watching the whole VFS directory tree.
+func NewWatcher(lib *Library) (*Watcher, error) {
+	root, err := vfs.NewPath("/")
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &Watcher{
+		lib:  lib,
+		fd:   fd,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		root: root.File(),
+		dirs: make(map[int]string),
+		done: make(chan struct{}),
+	}
+	w.watchTree("/")
+	events := make(chan []event)
+	go w.reader(events)
+	go w.worker(events)
+
+	return w, nil
+}
+
+// Close stops watching.
+func (w *Watcher) Close() {
+	w.file.Close()
+	<-w.done
+}
+
+// reader reads inotify events till the watcher is closed.
+func (w *Watcher) reader(events chan<- []event) {
+	defer close(events)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				logger.Error("library: inotify: %s", err)
+			}
+			return
+		}
+
+		var evs []event
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			off += syscall.SizeofInotifyEvent
+			name := string(buf[off : off+int(raw.Len)])
+			off += int(raw.Len)
+			evs = append(evs, event{
+				wd:   int(raw.Wd),
+				mask: raw.Mask,
+				name: strings.TrimRight(name, "\x00"),
+			})
+		}
+		events <- evs
+	}
+}
+
+// worker collects changed directories and refreshes library
+// when events burst is over.
+func (w *Watcher) worker(events <-chan []event) {
+	defer close(w.done)
+
+	// Changed VFS directories. Value is true if subdirectories
+	// have to be rescanned too.
+	dirty := make(map[string]bool)
+	var first time.Time
+	timer := time.NewTimer(watchDelay)
+	timer.Stop()
+
+	for {
+		select {
+		case evs, ok := <-events:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			for _, ev := range evs {
+				w.handle(ev, dirty)
+			}
+			if len(dirty) == 0 {
+				continue
+			}
+			if first.IsZero() {
+				first = time.Now()
+			}
+			d := watchDelay
+			if time.Since(first) > maxWatchDelay {
+				d = 0
+			}
+			// Drain timer which has already fired, otherwise
+			// flush happens right away.
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(d)
+		case <-timer.C:
+			w.flush(dirty)
+			dirty = make(map[string]bool)
+			first = time.Time{}
+		}
+	}
+}
+
+// handle marks directory affected by the event as changed.
+func (w *Watcher) handle(ev event, dirty map[string]bool) {
+	if ev.mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Some events are lost, so rescan everything.
+		logger.Warning("library: inotify queue overflow")
+		dirty["/"] = true
+		return
+	}
+	if ev.mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, ev.wd)
+		return
+	}
+	dir, ok := w.dirs[ev.wd]
+	if !ok {
+		return
+	}
+
+	if ev.mask&syscall.IN_ISDIR != 0 {
+		child := path.Join(dir, ev.name)
+		if ev.mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			w.watchTree(child)
+		} else {
+			w.unwatchTree(child)
+		}
+		dirty[child] = true
+	} else if _, ok := dirty[dir]; !ok {
+		dirty[dir] = false
+	}
+}
+
+// flush refreshes all changed directories.
+func (w *Watcher) flush(dirty map[string]bool) {
+	for dir, rec := range dirty {
+		if covered(dirty, dir) {
+			continue
+		}
+		err := w.lib.Refresh(dir, rec)
+		if err != nil {
+			logger.Warning("library: %s: %s", dir, err)
+		}
+	}
+}
+
+// watchTree adds watches for the VFS directory and all
+// its subdirectories.
+func (w *Watcher) watchTree(dir string) {
+	filepath.Walk(filepath.Join(w.root, dir),
+		func(p string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+			wd, err := syscall.InotifyAddWatch(w.fd, p, watchMask)
+			if err != nil {
+				logger.Warning("library: watch %s: %s", p, err)
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(w.root, p)
+			if err != nil {
+				return nil
+			}
+			w.dirs[wd] = path.Join("/", filepath.ToSlash(rel))
+
+			return nil
+		})
+}
+
+// unwatchTree removes watches for the VFS directory and all
+// its subdirectories.
+func (w *Watcher) unwatchTree(dir string) {
+	for wd, d := range w.dirs {
+		if d == dir || strings.HasPrefix(d, dir+"/") {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// covered returns true if dir is a subdirectory of one of recursively
+// rescanned directories.
+func covered(dirty map[string]bool, dir string) bool {
+	for d, rec := range dirty {
+		if rec && d != dir && (d == "/" || strings.HasPrefix(dir, d+"/")) {
+			return true
+		}
+	}
+
+	return false
+}
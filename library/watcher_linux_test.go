@@ -0,0 +1,98 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package library
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestWatcherHandle(t *testing.T) {
+	tests := []struct {
+		events   []event
+		expected map[string]bool
+	}{
+		// File changed.
+		{[]event{{2, syscall.IN_CLOSE_WRITE, "a.mp3"}},
+			map[string]bool{"/a": false}},
+		// Directory created.
+		{[]event{{1, syscall.IN_CREATE | syscall.IN_ISDIR, "b"}},
+			map[string]bool{"/b": true}},
+		// Directory removed.
+		{[]event{{1, syscall.IN_DELETE | syscall.IN_ISDIR, "a"}},
+			map[string]bool{"/a": true}},
+		// File change does not cancel directory rescan.
+		{[]event{{1, syscall.IN_MOVED_TO | syscall.IN_ISDIR, "a"},
+			{2, syscall.IN_CREATE, "a.mp3"}},
+			map[string]bool{"/a": true}},
+		{[]event{{2, syscall.IN_CREATE, "a.mp3"},
+			{2, syscall.IN_CREATE | syscall.IN_ISDIR, "c"}},
+			map[string]bool{"/a": false, "/a/c": true}},
+		// Everything is rescanned after events loss.
+		{[]event{{2, syscall.IN_DELETE, "a.mp3"},
+			{-1, syscall.IN_Q_OVERFLOW, ""}},
+			map[string]bool{"/": true, "/a": false}},
+		// Unknown and removed watches are ignored.
+		{[]event{{3, syscall.IN_CREATE, "a.mp3"}},
+			map[string]bool{}},
+		{[]event{{2, syscall.IN_IGNORED, ""},
+			{2, syscall.IN_CREATE, "a.mp3"}},
+			map[string]bool{}},
+	}
+
+	for _, test := range tests {
+		w := &Watcher{
+			fd:   -1,
+			root: "/nonexistent",
+			dirs: map[int]string{1: "/", 2: "/a"},
+		}
+		dirty := make(map[string]bool)
+		for _, ev := range test.events {
+			w.handle(ev, dirty)
+		}
+		if !reflect.DeepEqual(dirty, test.expected) {
+			t.Fatalf("%v expected but %v got for %v",
+				test.expected, dirty, test.events)
+		}
+	}
+}
+
+func TestWatcherCovered(t *testing.T) {
+	tests := []struct {
+		dirty    map[string]bool
+		dir      string
+		expected bool
+	}{
+		{map[string]bool{"/a": true, "/a/b": false}, "/a/b", true},
+		{map[string]bool{"/a": true, "/a/b/c": true}, "/a/b/c", true},
+		{map[string]bool{"/a": true}, "/a", false},
+		{map[string]bool{"/a": false, "/a/b": false}, "/a/b", false},
+		{map[string]bool{"/a": true, "/ab": false}, "/ab", false},
+		{map[string]bool{"/": true, "/a": false}, "/a", true},
+		{map[string]bool{"/": false, "/a": false}, "/a", false},
+	}
+
+	for _, test := range tests {
+		c := covered(test.dirty, test.dir)
+		if c != test.expected {
+			t.Fatalf("%t expected but %t got for %s in %v",
+				test.expected, c, test.dir, test.dirty)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !linux
+
+package library
+
+import "errors"
+
+// Watcher is not supported on this platform.
+type Watcher struct{}
+
+func NewWatcher(lib *Library) (*Watcher, error) {
+	return nil, errors.New("filesystem watching is not supported")
+}
+
+func (w *Watcher) Close() {
+}
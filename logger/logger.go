@@ -21,9 +21,11 @@
 package logger
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 )
 
 // Available log levels.
@@ -71,6 +73,22 @@ func (l *logger) output(level int, format string, args ...interface{}) {
 	l.stdLogger.Output(4, line)
 }
 
+// ParseLevel returns log level by its case-insensitive name.
+// "none" name stands for LevelNone.
+func ParseLevel(name string) (int, error) {
+	name = strings.ToUpper(name)
+	if name == "NONE" {
+		return LevelNone, nil
+	}
+	for l, n := range levelNames {
+		if n == name {
+			return l, nil
+		}
+	}
+
+	return 0, errors.New("invalid log level")
+}
+
 // Level returns current log level used by the standard logger.
 func Level() int {
 	return defaultLogger.level
@@ -18,48 +18,183 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	"github.com/vchimishuk/chub/alsa"
 	"github.com/vchimishuk/chub/format"
 	"github.com/vchimishuk/chub/format/ffmpeg"
+	"github.com/vchimishuk/chub/library"
+	"github.com/vchimishuk/chub/logger"
+	"github.com/vchimishuk/chub/output"
 	"github.com/vchimishuk/chub/player"
 	"github.com/vchimishuk/chub/server/cmd"
 	"github.com/vchimishuk/chub/server/notif"
+	"github.com/vchimishuk/chub/store"
 	"github.com/vchimishuk/chub/vfs"
 )
 
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
 func main() {
-	ffmpegFmt := ffmpeg.NewFormat()
+	cfgFile := flag.String("config", "", "configuration file path")
+	flag.Parse()
+
+	var opts *options
+	var err error
+	if *cfgFile != "" {
+		opts, err = loadOptions(*cfgFile, true)
+	} else {
+		opts, err = loadOptions(configFile(), false)
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
+	logger.SetLevel(opts.logLevel)
 
 	// Initialize VFS.
-	// vfs.RegisterFormat(flac.Format)
-	// vfs.RegisterFormat(mp3.Format)
+	ffmpegFmt := format.Subset(ffmpeg.NewFormat(), opts.formats)
 	format.Register(ffmpegFmt)
 
-	err := vfs.SetRoot("/home/vchimishuk/server/Heavy Metal")
+	vfs.SetGapMode(opts.cueGaps)
+	vfs.SetHTOAMode(opts.cueHTOA)
+	vfs.SetCueCharset(opts.cueCharset)
+	err = vfs.SetRoot(opts.root)
 	if err != nil {
-		panic(err)
+		fatal("%s: %s", opts.root, err)
+	}
+
+	var outs []player.NamedOutput
+	for _, o := range opts.outputs {
+		var out player.Output
+		switch o.driver {
+		case "alsa":
+			out = alsa.New(o.device)
+		case "null":
+			out = output.NewNull()
+		case "wav":
+			out = output.NewWav(o.device)
+		case "pipe":
+			out = output.NewPipe(o.device)
+		case "stdout":
+			out = output.NewStdout()
+		case "http":
+			enc, err := ffmpeg.NewEncoder(o.format, o.bitrate)
+			if err != nil {
+				fatal("output %s: %s", o.name, err)
+			}
+			out, err = output.NewHTTP(o.device, enc)
+			if err != nil {
+				fatal("output %s: %s", o.name, err)
+			}
+		}
+		outs = append(outs, player.NamedOutput{
+			Name:    o.name,
+			Output:  out,
+			Enabled: o.enabled,
+		})
+	}
+	var mixer player.Mixer
+	if opts.mixerType == "alsa" {
+		m, err := alsa.NewMixer(opts.mixerDevice, opts.mixerControl)
+		if err != nil {
+			fatal("%s", err)
+		}
+		defer m.Close()
+		mixer = m
+	} else {
+		mixer = player.NewSoftMixer()
 	}
+	pl := player.New([]format.Format{ffmpegFmt}, outs, mixer)
+	pl.SetCrossfade(opts.crossfade)
+	pl.SetReplayGain(opts.replayGain)
+	pl.SetOutputFormat(opts.sampleRate, opts.channels, ffmpeg.NewResampler)
 
-	// fmts := []player.Format{
-	// 	flac.Format,
-	// 	mp3.Format,
-	// }
-	output := alsa.New()
-	pl := player.New([]format.Format{ffmpegFmt}, output)
+	lib := library.New(filepath.Join(opts.stateDir, "library"))
+	libErr := lib.Load()
+	if libErr != nil && !os.IsNotExist(libErr) {
+		logger.Error("failed to load library: %s", libErr)
+	}
 
-	notifSrv := notif.NewServer(pl)
-	notifSrv.Listen("127.0.0.1", 5225)
-	fmt.Println("Notification server started")
+	// Library is used to find moved playlist tracks, so it has to be
+	// loaded before playlists.
+	st, err := store.New(opts.stateDir)
+	if err != nil {
+		fatal("%s", err)
+	}
+	st.SetResolver(lib.Resolve)
+	err = st.Restore(pl)
+	if err != nil {
+		logger.Error("failed to restore state: %s", err)
+	}
+	st.AutoSave(pl)
+	// Tracks not found on restore can appear after library update
+	// or when music directory is mounted.
+	lib.AddChangeHandler(func(*library.Change) {
+		go st.ResolveMissing(pl)
+	})
+
+	if opts.libraryWatch {
+		w, err := library.NewWatcher(lib)
+		if err != nil {
+			logger.Error("failed to watch music directory: %s", err)
+		} else {
+			defer w.Close()
+		}
+	}
+
+	notifSrv := notif.NewServer(pl, lib, opts.notifTick)
+	// Library change handlers are set, so the update can be started.
+	if libErr != nil {
+		go func() {
+			err := lib.Update()
+			if err != nil {
+				logger.Error("library update failed: %s", err)
+			} else {
+				logger.Info("library updated: %d tracks", lib.Len())
+			}
+		}()
+	}
+	err = notifSrv.Listen(opts.notifAddr, opts.notifPort)
+	if err != nil {
+		fatal("%s", err)
+	}
+	logger.Info("notification server started")
 	go notifSrv.Serve()
 
-	cmdSrv := cmd.NewServer(pl)
-	cmdSrv.Listen("127.0.0.1", 5115)
-	fmt.Println("Command server started")
+	cmdSrv := cmd.NewServer(pl, lib)
+	err = cmdSrv.Listen(opts.cmdAddr, opts.cmdPort)
+	if err != nil {
+		fatal("%s", err)
+	}
+	logger.Info("command server started")
+
+	// Shutdown gracefully on signals, the same way KILL command does.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cmdSrv.Close()
+	}()
+
 	cmdSrv.Serve()
-	fmt.Println("Command server stopped")
+	logger.Info("command server stopped")
+
+	// Changes are saved by AutoSave, final save stores playback
+	// position too.
+	err = st.Save(pl)
+	if err != nil {
+		logger.Error("failed to save state: %s", err)
+	}
 
 	notifSrv.Close()
-	fmt.Println("Notification server stopped")
+	logger.Info("notification server stopped")
+	pl.Close()
 }
@@ -18,8 +18,6 @@
 package mp3
 
 import (
-	"strconv"
-
 	"github.com/vchimishuk/chub/mp3/id3tag"
 	"github.com/vchimishuk/chub/player"
 	"github.com/vchimishuk/chub/vfs"
@@ -50,18 +48,8 @@ func (f format) Tag(file string) (*vfs.Tag, error) {
 	if err != nil {
 		return nil, err
 	}
-	number, err := strconv.Atoi(id3Tag.Number())
-	if err != nil {
-		number = 0
-	}
-
-	tag := &vfs.Tag{
-		Artist: id3Tag.Artist(),
-		Album:  id3Tag.Album(),
-		Title:  id3Tag.Title(),
-		Number: number}
 
-	return tag, nil
+	return vfs.NewTag(id3Tag.Frames()), nil
 }
 
 func (f format) Decoder() player.Decoder {
@@ -41,7 +41,7 @@ enum id3_field_type id3_hlp_get_frame_type(struct id3_frame *frame)
 
 char *id3_hlp_get_frame_string(struct id3_frame *frame)
 {
-    char *str;
+    char *str = NULL;
 
     if (id3_field_getnstrings(&frame->fields[1]) != 0) {
         str = (char *) id3_field_getstrings(&frame->fields[1], 0);
@@ -52,3 +52,19 @@ char *id3_hlp_get_frame_string(struct id3_frame *frame)
 
     return str;
 }
+
+char *id3_hlp_get_field_string(struct id3_frame *frame,
+    unsigned int field_num)
+{
+    id3_ucs4_t const *str;
+
+    if (field_num >= frame->nfields) {
+        return NULL;
+    }
+    str = id3_field_getstring(&frame->fields[field_num]);
+    if (str == NULL) {
+        return NULL;
+    }
+
+    return (char *) id3_ucs4_utf8duplicate(str);
+}
@@ -41,4 +41,11 @@ enum id3_field_type id3_hlp_get_frame_type(struct id3_frame *frame);
  */
 char *id3_hlp_get_frame_string(struct id3_frame *frame);
 
+/*
+ * Returns string value of the frame's field with the given index
+ * or NULL if field is missing. Returned string must be freed.
+ */
+char *id3_hlp_get_field_string(struct id3_frame *frame,
+    unsigned int field_num);
+
 #endif // ID3_HLP_H
@@ -62,6 +62,15 @@ func Parse(filename string) (tag Tag, err error) {
 		// XXX: As I understood cId memory will be GCed with id
 		//      (they share same memory).
 		id := C.GoString(cId)
+		if id == "TXXX" {
+			// User defined text frames are keyed by their
+			// description, e.g. REPLAYGAIN_TRACK_GAIN.
+			desc := fieldString(cFrame, 1)
+			if desc != "" {
+				tag.frames[desc] = fieldString(cFrame, 2)
+			}
+			continue
+		}
 		cVal := C.id3_hlp_get_frame_string(cFrame)
 		val := C.GoString(cVal)
 
@@ -71,6 +80,17 @@ func Parse(filename string) (tag Tag, err error) {
 	return tag, nil
 }
 
+// fieldString returns string value of the frame's field.
+func fieldString(cFrame *C.struct_id3_frame, n C.uint) string {
+	cStr := C.id3_hlp_get_field_string(cFrame, n)
+	if cStr == nil {
+		return ""
+	}
+	defer C.free(unsafe.Pointer(cStr))
+
+	return C.GoString(cStr)
+}
+
 // Artist returns name of the artist.
 func (tag Tag) Artist() string {
 	return tag.frames["TPE1"]
@@ -101,6 +121,17 @@ func (tag Tag) Year() string {
 	return year
 }
 
+// Frames returns all text frames by their IDs. User defined text frames
+// (TXXX) are returned by their descriptions.
+func (tag Tag) Frames() map[string]string {
+	frames := make(map[string]string, len(tag.frames))
+	for id, v := range tag.frames {
+		frames[id] = v
+	}
+
+	return frames
+}
+
 // Comment returns track's comment string.
 //func (tag *Tag) Comment() string {
 //	fmt.Printf("%v\n", tag.frames)
@@ -0,0 +1,396 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vchimishuk/chub/config"
+	"github.com/vchimishuk/chub/cue"
+	"github.com/vchimishuk/chub/logger"
+	"github.com/vchimishuk/chub/player"
+	"github.com/vchimishuk/chub/vfs"
+)
+
+// Supported configuration file keys.
+const (
+	// Music directory to be used as VFS root.
+	optRoot = "vfs.root"
+	// Command server listen address and port.
+	optCmdAddr = "server.command.address"
+	optCmdPort = "server.command.port"
+	// Notification server listen address and port.
+	optNotifAddr = "server.notification.address"
+	optNotifPort = "server.notification.port"
+	// Position notification event interval in milliseconds.
+	optNotifTick = "server.notification.tick"
+	// Output driver name (alsa, null, wav, pipe, stdout or http), its
+	// device and name shown to clients. Device is a file path for wav
+	// and pipe drivers and listen address for http one.
+	optOutputDriver = "output.driver"
+	optOutputDevice = "output.device"
+	optOutputName   = "output.name"
+	// Stream format and bitrate in kbit/s for http driver.
+	optOutputFormat  = "output.format"
+	optOutputBitrate = "output.bitrate"
+	// Additional outputs options prefix. output.N.driver,
+	// output.N.device, output.N.name, output.N.format,
+	// output.N.bitrate and output.N.enabled keys describe N-th
+	// additional output, N is in 1..maxOutputs range.
+	optOutputPrefix = "output."
+	// Volume control: software or alsa.
+	optMixerType = "mixer.type"
+	// ALSA mixer card and simple control name.
+	optMixerDevice  = "mixer.device"
+	optMixerControl = "mixer.control"
+	// Log level: debug, info, warning, error, fatal or none.
+	optLogLevel = "log.level"
+	// Directory to keep playlists, player state and library index in.
+	optStateDir = "state.dir"
+	// Comma separated list of enabled audio file extensions.
+	optFormats = "formats"
+	// Crossfade duration in seconds, 0 disables crossfade.
+	optCrossfade = "player.crossfade"
+	// ReplayGain mode: off, track, album or auto.
+	optReplayGain = "player.replaygain"
+	// Fixed output sample rate and number of channels all tracks
+	// are converted to. Sample rate 0 disables conversion.
+	optSampleRate = "player.samplerate"
+	optChannels   = "player.channels"
+	// CUE gaps handling mode: append, prepend or skip.
+	optCueGaps = "cue.gaps"
+	// CUE hidden track one audio handling mode: skip, prepend or track.
+	optCueHTOA = "cue.htoa"
+	// Charset of CUE sheets which are not in UTF-8 or UTF-16,
+	// auto to detect.
+	optCueCharset = "cue.charset"
+	// Watch music directory for changes to keep library up to date.
+	optLibraryWatch = "library.watch"
+)
+
+var knownOptions = []string{
+	optRoot,
+	optCmdAddr,
+	optCmdPort,
+	optNotifAddr,
+	optNotifPort,
+	optNotifTick,
+	optOutputDriver,
+	optOutputDevice,
+	optOutputName,
+	optOutputFormat,
+	optOutputBitrate,
+	optMixerType,
+	optMixerDevice,
+	optMixerControl,
+	optLogLevel,
+	optStateDir,
+	optFormats,
+	optCrossfade,
+	optReplayGain,
+	optSampleRate,
+	optChannels,
+	optCueGaps,
+	optCueHTOA,
+	optCueCharset,
+	optLibraryWatch,
+}
+
+// Maximum number of additional outputs.
+const maxOutputs = 8
+
+func init() {
+	for i := 1; i <= maxOutputs; i++ {
+		for _, k := range []string{"driver", "device", "name",
+			"format", "bitrate", "enabled"} {
+			knownOptions = append(knownOptions, outputKey(i, k))
+		}
+	}
+}
+
+type outputOptions struct {
+	name    string
+	driver  string
+	device  string
+	format  string
+	bitrate int
+	enabled bool
+}
+
+type options struct {
+	root         string
+	cmdAddr      string
+	cmdPort      int
+	notifAddr    string
+	notifPort    int
+	notifTick    time.Duration
+	outputs      []*outputOptions
+	mixerType    string
+	mixerDevice  string
+	mixerControl string
+	logLevel     int
+	stateDir     string
+	formats      []string
+	crossfade    int
+	replayGain   player.ReplayGainMode
+	sampleRate   int
+	channels     int
+	cueGaps      vfs.GapMode
+	cueHTOA      vfs.HTOAMode
+	cueCharset   string
+	libraryWatch bool
+}
+
+// configFile returns default configuration file path:
+// $XDG_CONFIG_HOME/chub/chub.conf or ~/.config/chub/chub.conf.
+func configFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+
+	return filepath.Join(dir, "chub", "chub.conf")
+}
+
+// stateDir returns default directory to keep playlists and player
+// state in: $XDG_STATE_HOME/chub or ~/.local/state/chub.
+func stateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "state")
+	}
+
+	return filepath.Join(dir, "chub")
+}
+
+// loadOptions reads configuration file. Missing file is not an error
+// unless required is true, default values are used in this case.
+func loadOptions(file string, required bool) (*options, error) {
+	cfg, err := config.ParseFile(file)
+	if os.IsNotExist(err) && !required {
+		cfg, err = config.Parse(strings.NewReader(""))
+	}
+	if err != nil {
+		return nil, optionsError(file, err)
+	}
+
+	opts, err := parseOptions(cfg)
+	if err != nil {
+		return nil, optionsError(file, err)
+	}
+
+	return opts, nil
+}
+
+func parseOptions(cfg *config.Config) (*options, error) {
+	err := cfg.Check(knownOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &options{
+		root:         cfg.String(optRoot, filepath.Join(os.Getenv("HOME"), "Music")),
+		cmdAddr:      cfg.String(optCmdAddr, "127.0.0.1"),
+		notifAddr:    cfg.String(optNotifAddr, "127.0.0.1"),
+		mixerType:    cfg.String(optMixerType, "software"),
+		mixerDevice:  cfg.String(optMixerDevice, "default"),
+		mixerControl: cfg.String(optMixerControl, "Master"),
+		stateDir:     cfg.String(optStateDir, stateDir()),
+	}
+
+	opts.cmdPort, err = port(cfg, optCmdPort, 5115)
+	if err != nil {
+		return nil, err
+	}
+	opts.notifPort, err = port(cfg, optNotifPort, 5225)
+	if err != nil {
+		return nil, err
+	}
+	tick, err := cfg.Int(optNotifTick, 1000)
+	if err != nil {
+		return nil, err
+	}
+	if tick < 0 {
+		return nil, cfg.ValueError(optNotifTick, "negative interval")
+	}
+	opts.notifTick = time.Duration(tick) * time.Millisecond
+
+	opts.crossfade, err = cfg.Int(optCrossfade, 0)
+	if err != nil {
+		return nil, err
+	}
+	if opts.crossfade < 0 {
+		return nil, cfg.ValueError(optCrossfade, "negative duration")
+	}
+
+	opts.replayGain, err = player.ParseReplayGainMode(
+		cfg.String(optReplayGain, player.ReplayGainOff.String()))
+	if err != nil {
+		return nil, cfg.ValueError(optReplayGain, "unsupported mode")
+	}
+
+	opts.sampleRate, err = cfg.Int(optSampleRate, 0)
+	if err != nil {
+		return nil, err
+	}
+	if opts.sampleRate < 0 {
+		return nil, cfg.ValueError(optSampleRate, "negative sample rate")
+	}
+	opts.channels, err = cfg.Int(optChannels, 2)
+	if err != nil {
+		return nil, err
+	}
+	if opts.channels < 1 || opts.channels > 8 {
+		return nil, cfg.ValueError(optChannels, "must be in 1..8 range")
+	}
+
+	opts.cueGaps, err = vfs.ParseGapMode(
+		cfg.String(optCueGaps, vfs.GapAppend.String()))
+	if err != nil {
+		return nil, cfg.ValueError(optCueGaps, "unsupported mode")
+	}
+	opts.cueHTOA, err = vfs.ParseHTOAMode(
+		cfg.String(optCueHTOA, vfs.HTOASkip.String()))
+	if err != nil {
+		return nil, cfg.ValueError(optCueHTOA, "unsupported mode")
+	}
+	opts.cueCharset = cfg.String(optCueCharset, "auto")
+	if opts.cueCharset == "auto" {
+		opts.cueCharset = ""
+	} else if err := cue.CheckCharset(opts.cueCharset); err != nil {
+		return nil, cfg.ValueError(optCueCharset, err.Error())
+	}
+
+	opts.libraryWatch, err = cfg.Bool(optLibraryWatch, true)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := outputOpts(cfg, optOutputPrefix, "alsa")
+	if err != nil {
+		return nil, err
+	}
+	out.enabled = true
+	opts.outputs = append(opts.outputs, out)
+	for i := 1; i <= maxOutputs; i++ {
+		if !cfg.Defined(outputKey(i, "driver")) {
+			continue
+		}
+		out, err := outputOpts(cfg, outputKey(i, ""), "")
+		if err != nil {
+			return nil, err
+		}
+		out.enabled, err = cfg.Bool(outputKey(i, "enabled"), true)
+		if err != nil {
+			return nil, err
+		}
+		opts.outputs = append(opts.outputs, out)
+	}
+	switch opts.mixerType {
+	case "software", "alsa":
+	default:
+		return nil, cfg.ValueError(optMixerType, "unsupported mixer")
+	}
+
+	opts.logLevel, err = logger.ParseLevel(cfg.String(optLogLevel, "info"))
+	if err != nil {
+		return nil, cfg.ValueError(optLogLevel, err.Error())
+	}
+
+	for _, f := range strings.Split(cfg.String(optFormats, "ape,flac,mp3,ogg"), ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			opts.formats = append(opts.formats, f)
+		}
+	}
+	if len(opts.formats) == 0 {
+		return nil, cfg.ValueError(optFormats, "no formats enabled")
+	}
+
+	return opts, nil
+}
+
+// outputOpts parses options of the output which keys start with
+// the prefix.
+func outputOpts(cfg *config.Config, prefix string,
+	defDriver string) (*outputOptions, error) {
+
+	driverKey := prefix + "driver"
+	deviceKey := prefix + "device"
+	out := &outputOptions{
+		driver: cfg.String(driverKey, defDriver),
+		device: cfg.String(deviceKey, "default"),
+		format: cfg.String(prefix+"format", "mp3"),
+	}
+	out.name = cfg.String(prefix+"name", out.driver)
+
+	switch out.driver {
+	case "alsa", "null", "stdout":
+	case "wav", "pipe":
+		if !cfg.Defined(deviceKey) {
+			return nil, cfg.ValueError(driverKey,
+				deviceKey+" is required")
+		}
+	case "http":
+		out.device = cfg.String(deviceKey, ":8000")
+	default:
+		return nil, cfg.ValueError(driverKey, "unsupported driver")
+	}
+
+	var err error
+	out.bitrate, err = cfg.Int(prefix+"bitrate", 128)
+	if err != nil {
+		return nil, err
+	}
+	if out.bitrate < 0 {
+		return nil, cfg.ValueError(prefix+"bitrate",
+			"negative bitrate")
+	}
+
+	return out, nil
+}
+
+func outputKey(n int, name string) string {
+	return fmt.Sprintf("%s%d.%s", optOutputPrefix, n, name)
+}
+
+func port(cfg *config.Config, name string, def int) (int, error) {
+	p, err := cfg.Int(name, def)
+	if err != nil {
+		return 0, err
+	}
+	if p <= 0 || p > 65535 {
+		return 0, cfg.ValueError(name, "invalid port number")
+	}
+
+	return p, nil
+}
+
+// optionsError prefixes error with the configuration file name, so
+// config.Error looks like "FILE:LINE: MESSAGE".
+func optionsError(file string, err error) error {
+	if _, ok := err.(*config.Error); ok {
+		return fmt.Errorf("%s:%s", file, err)
+	}
+
+	return fmt.Errorf("%s: %s", file, err)
+}
@@ -0,0 +1,306 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vchimishuk/chub/cnet"
+	"github.com/vchimishuk/chub/format"
+	"github.com/vchimishuk/chub/vfs"
+)
+
+const (
+	// Number of stream bytes between ICY metadata blocks.
+	icyMetaInt = 16000
+	// Maximum number of encoded chunks queued for a client. Clients
+	// which can't keep up with the stream are disconnected.
+	clientQueueLen = 64
+	// Time given to a client to send its request.
+	requestTimeout = 10 * time.Second
+	// Stream name sent to clients.
+	streamName = "chub"
+)
+
+// httpSink encodes PCM data and sends it to all connected HTTP
+// clients, so as Icecast server does.
+type httpSink struct {
+	enc format.Encoder
+	srv *cnet.Server
+	// Mutex guards title.
+	mu sync.Mutex
+	// Current track title sent in ICY metadata.
+	title string
+}
+
+// NewHTTP returns output which streams audio encoded with enc over
+// HTTP. Clients are accepted on addr ("host:port" or ":port") right
+// away, even if output is not open yet, and receive stream data while
+// output is open and not paused.
+func NewHTTP(addr string, enc format.Encoder) (*Output, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", p)
+	}
+	if host == "" {
+		host = "0.0.0.0"
+	}
+
+	s := &httpSink{enc: enc}
+	s.srv = cnet.NewServer(func(conn net.Conn, srv *cnet.Server) cnet.Client {
+		return newStreamClient(conn, s)
+	})
+	err = s.srv.Listen(host, port)
+	if err != nil {
+		return nil, err
+	}
+	go s.srv.Serve()
+
+	return newOutput(s), nil
+}
+
+func (s *httpSink) open() error {
+	return nil
+}
+
+func (s *httpSink) write(buf []byte, rate int, channels int) error {
+	data, err := s.enc.Encode(buf, rate, channels)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	for _, c := range s.srv.Clients() {
+		c.(*streamClient).send(data)
+	}
+
+	return nil
+}
+
+// close keeps clients connected, so they continue to receive
+// the stream when playback is resumed.
+func (s *httpSink) close() {
+}
+
+func (s *httpSink) setTrack(track *vfs.Track) {
+	title := track.Tag.Title
+	if title == "" {
+		title = path.Base(track.Path.String())
+	} else if track.Tag.Artist != "" {
+		title = track.Tag.Artist + " - " + title
+	}
+
+	s.mu.Lock()
+	s.title = title
+	s.mu.Unlock()
+}
+
+func (s *httpSink) streamTitle() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.title
+}
+
+// streamClient is a single HTTP stream listener.
+type streamClient struct {
+	conn net.Conn
+	sink *httpSink
+	data chan []byte
+	// Closed when client is closed.
+	done      chan struct{}
+	closeOnce sync.Once
+	// Mutex guards ready and closed fields.
+	mu sync.Mutex
+	// Client is ready to receive stream data after response
+	// header is sent.
+	ready  bool
+	closed bool
+	// Number of stream bytes between metadata blocks or 0 if client
+	// has not requested metadata.
+	metaInt int
+	// Number of stream bytes sent since the last metadata block.
+	sent int
+	// Title sent in the last metadata block.
+	title string
+}
+
+func newStreamClient(conn net.Conn, s *httpSink) *streamClient {
+	return &streamClient{
+		conn: conn,
+		sink: s,
+		data: make(chan []byte, clientQueueLen),
+		done: make(chan struct{}),
+	}
+}
+
+func (c *streamClient) Serve() {
+	defer c.Close()
+
+	c.conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	req, err := http.ReadRequest(bufio.NewReader(c.conn))
+	if err != nil {
+		return
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		fmt.Fprintf(c.conn, "HTTP/1.0 405 Method Not Allowed\r\n"+
+			"Allow: GET, HEAD\r\n\r\n")
+		return
+	}
+	if req.Header.Get("Icy-MetaData") == "1" {
+		c.metaInt = icyMetaInt
+	}
+	c.conn.SetReadDeadline(time.Time{})
+
+	hdr := "HTTP/1.0 200 OK\r\n" +
+		"Content-Type: " + c.sink.enc.MIME() + "\r\n" +
+		"Cache-Control: no-cache, no-store\r\n" +
+		"icy-name: " + streamName + "\r\n"
+	if c.metaInt > 0 {
+		hdr += "icy-metaint: " + strconv.Itoa(c.metaInt) + "\r\n"
+	}
+	_, err = c.conn.Write([]byte(hdr + "\r\n"))
+	if err != nil || req.Method == "HEAD" {
+		return
+	}
+	err = c.write(c.sink.enc.Header())
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.ready = true
+	c.mu.Unlock()
+	for {
+		select {
+		case data := <-c.data:
+			err := c.write(data)
+			if err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *streamClient) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+		close(c.done)
+		err = c.conn.Close()
+	})
+
+	return err
+}
+
+func (c *streamClient) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// send queues stream data for the client. Client is closed if its
+// queue is full.
+func (c *streamClient) send(data []byte) {
+	c.mu.Lock()
+	ready := c.ready && !c.closed
+	c.mu.Unlock()
+	if !ready {
+		return
+	}
+
+	select {
+	case c.data <- data:
+	default:
+		c.Close()
+	}
+}
+
+// write writes stream data interleaved with ICY metadata blocks if
+// client requested them.
+func (c *streamClient) write(data []byte) error {
+	if c.metaInt == 0 {
+		_, err := c.conn.Write(data)
+		return err
+	}
+
+	for len(data) > 0 {
+		n := c.metaInt - c.sent
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err := c.conn.Write(data[:n])
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		c.sent += n
+
+		if c.sent == c.metaInt {
+			c.sent = 0
+			title := c.sink.streamTitle()
+			meta := []byte{0}
+			if title != c.title {
+				meta = icyMetadata(title)
+				c.title = title
+			}
+			_, err := c.conn.Write(meta)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// icyMetadata returns ICY metadata block with the stream title.
+// Block starts with its length in 16 byte units followed by the
+// zero padded metadata string.
+func icyMetadata(title string) []byte {
+	// Quote terminates the title value.
+	title = strings.Replace(title, "'", "’", -1)
+	s := "StreamTitle='" + title + "';"
+	if len(s) > 255*16 {
+		s = s[:255*16-2] + "';"
+	}
+	n := (len(s) + 15) / 16
+	b := make([]byte, 1+n*16)
+	b[0] = byte(n)
+	copy(b[1:], s)
+
+	return b
+}
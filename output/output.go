@@ -0,0 +1,337 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+// Package output implements output drivers which do not need a sound
+// card: null sink, WAV file, FIFO and standard output. All of them
+// consume data in real time, as a sound card does, so playback
+// position and timing are the same as with the real device.
+package output
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vchimishuk/chub/vfs"
+)
+
+const (
+	// Buffer size in milliseconds.
+	bufferTime = 500
+	// Wait returns when at least bufferTime/waitDivisor of the buffer
+	// is free.
+	waitDivisor = 4
+	// Default PCM parameters set on open.
+	defaultRate     = 44100
+	defaultChannels = 2
+	// Signed 16 bit samples.
+	sampleSize = 2
+	// Write polling interval while paused with full buffer.
+	pausedDelay = 10 * time.Millisecond
+)
+
+var errClosed = errors.New("output is closed")
+
+// sink receives PCM data consumed from the output buffer.
+type sink interface {
+	// open is called when output is opened.
+	open() error
+	// write writes signed 16 bit little endian PCM data of the given
+	// format.
+	write(buf []byte, rate int, channels int) error
+	// close is called when output is closed.
+	close()
+}
+
+// trackSink is a sink which needs to know the track being played.
+type trackSink interface {
+	setTrack(track *vfs.Track)
+}
+
+// Output is an output driver with virtual buffer which is drained
+// in real time. Consumed data is passed to the sink.
+type Output struct {
+	sink sink
+	// Mutex guards fields below as Wait is called from
+	// a separate goroutine.
+	mu       sync.Mutex
+	open     bool
+	paused   bool
+	rate     int
+	channels int
+	// Number of bytes in the buffer at the clock time.
+	fill  int
+	clock time.Time
+}
+
+func newOutput(s sink) *Output {
+	return &Output{
+		sink:     s,
+		rate:     defaultRate,
+		channels: defaultChannels,
+	}
+}
+
+// NewNull returns output which discards all data.
+func NewNull() *Output {
+	return newOutput(nullSink{})
+}
+
+func (o *Output) Open() error {
+	err := o.sink.open()
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.open = true
+	o.paused = false
+	o.rate = defaultRate
+	o.channels = defaultChannels
+	o.fill = 0
+	o.clock = time.Now()
+
+	return nil
+}
+
+func (o *Output) IsOpen() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.open
+}
+
+func (o *Output) SampleRate() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.rate
+}
+
+func (o *Output) SetSampleRate(rate int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.update()
+	o.rate = rate
+}
+
+func (o *Output) Channels() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.channels
+}
+
+func (o *Output) SetChannels(channels int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.update()
+	o.channels = channels
+}
+
+func (o *Output) Wait(maxDelay int) (ok bool, err error) {
+	deadline := time.Now().Add(time.Duration(maxDelay) * time.Millisecond)
+	for {
+		o.mu.Lock()
+		if !o.open {
+			o.mu.Unlock()
+			return false, errClosed
+		}
+		o.update()
+		need := o.size()/waitDivisor - o.avail()
+		delay := time.Until(deadline)
+		if need <= 0 {
+			o.mu.Unlock()
+			return true, nil
+		}
+		if !o.paused {
+			d := o.duration(need)
+			if d < delay {
+				delay = d
+			}
+		}
+		o.mu.Unlock()
+
+		if time.Until(deadline) <= 0 {
+			return false, nil
+		}
+		time.Sleep(delay)
+	}
+}
+
+func (o *Output) AvailUpdate() (size int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.open {
+		return 0, errClosed
+	}
+	o.update()
+
+	return o.avail(), nil
+}
+
+// Write blocks until there is some free space in the buffer, so as
+// ALSA output in blocking mode does.
+func (o *Output) Write(buf []byte) (written int, err error) {
+	o.mu.Lock()
+	for {
+		if !o.open {
+			o.mu.Unlock()
+			return 0, errClosed
+		}
+		o.update()
+		if o.avail() > 0 {
+			break
+		}
+		d := o.duration(o.frameSize())
+		if o.paused {
+			d = pausedDelay
+		}
+		o.mu.Unlock()
+		time.Sleep(d)
+		o.mu.Lock()
+	}
+	n := o.avail()
+	if n > len(buf) {
+		n = len(buf)
+	}
+	rate, channels := o.rate, o.channels
+	o.mu.Unlock()
+
+	err = o.sink.write(buf[:n], rate, channels)
+	if err != nil {
+		return 0, err
+	}
+
+	o.mu.Lock()
+	o.fill += n
+	o.mu.Unlock()
+
+	return n, nil
+}
+
+func (o *Output) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.update()
+	o.fill = 0
+}
+
+func (o *Output) Drain() {
+	o.mu.Lock()
+	o.update()
+	d := o.duration(o.fill)
+	paused := o.paused
+	o.mu.Unlock()
+
+	if !paused {
+		time.Sleep(d)
+	}
+	o.Reset()
+}
+
+func (o *Output) Pause() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.update()
+	o.paused = !o.paused
+}
+
+func (o *Output) Paused() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.paused
+}
+
+func (o *Output) Close() {
+	o.mu.Lock()
+	o.open = false
+	o.mu.Unlock()
+	o.sink.close()
+}
+
+// SetTrack passes the track being played to the sink if the sink
+// is interested in it.
+func (o *Output) SetTrack(track *vfs.Track) {
+	if s, ok := o.sink.(trackSink); ok {
+		s.setTrack(track)
+	}
+}
+
+// update removes data consumed since the last update from the buffer.
+func (o *Output) update() {
+	now := time.Now()
+	if o.paused || o.fill == 0 {
+		o.clock = now
+		return
+	}
+	consumed := int(int64(now.Sub(o.clock)) * int64(o.byteRate()) /
+		int64(time.Second))
+	consumed -= consumed % o.frameSize()
+	if consumed >= o.fill {
+		o.fill = 0
+		o.clock = now
+	} else if consumed > 0 {
+		o.fill -= consumed
+		o.clock = o.clock.Add(o.duration(consumed))
+	}
+}
+
+// size returns buffer size in bytes.
+func (o *Output) size() int {
+	s := o.byteRate() * bufferTime / 1000
+
+	return s - s%o.frameSize()
+}
+
+// avail returns free buffer space in bytes.
+func (o *Output) avail() int {
+	a := o.size() - o.fill
+	if a < 0 {
+		return 0
+	}
+
+	return a
+}
+
+func (o *Output) frameSize() int {
+	return o.channels * sampleSize
+}
+
+func (o *Output) byteRate() int {
+	return o.rate * o.frameSize()
+}
+
+// duration returns playing time of n bytes.
+func (o *Output) duration(n int) time.Duration {
+	return time.Duration(int64(n) * int64(time.Second) / int64(o.byteRate()))
+}
+
+type nullSink struct{}
+
+func (nullSink) open() error {
+	return nil
+}
+
+func (nullSink) write(buf []byte, rate int, channels int) error {
+	return nil
+}
+
+func (nullSink) close() {
+}
@@ -0,0 +1,327 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vchimishuk/chub/vfs"
+)
+
+func TestOutput(t *testing.T) {
+	o := NewNull()
+	if _, err := o.AvailUpdate(); err == nil {
+		t.Fatal()
+	}
+	err := o.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.SetSampleRate(8000)
+	o.SetChannels(1)
+	// 500ms of 8kHz mono 16 bit data.
+	size := 8000
+	avail, err := o.AvailUpdate()
+	if err != nil || avail != size {
+		t.Fatalf("%d expected but %d got", size, avail)
+	}
+
+	o.Pause()
+	n, err := o.Write(make([]byte, size+100))
+	if err != nil || n != size {
+		t.Fatalf("%d expected but %d got", size, n)
+	}
+	time.Sleep(50 * time.Millisecond)
+	// Nothing is consumed while paused.
+	if avail, _ := o.AvailUpdate(); avail != 0 {
+		t.Fatal(avail)
+	}
+	ok, err := o.Wait(10)
+	if err != nil || ok {
+		t.Fatal()
+	}
+
+	o.Pause()
+	ok, err = o.Wait(1000)
+	if err != nil || !ok {
+		t.Fatal()
+	}
+	avail, _ = o.AvailUpdate()
+	if avail < size/waitDivisor || avail == size {
+		t.Fatal(avail)
+	}
+
+	o.Reset()
+	if avail, _ := o.AvailUpdate(); avail != size {
+		t.Fatal(avail)
+	}
+	o.Close()
+	if _, err := o.Write([]byte{0, 0}); err == nil {
+		t.Fatal()
+	}
+}
+
+func TestWav(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "out.wav")
+
+	o := NewWav(file)
+	err = o.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Write([]byte{1, 2, 3, 4})
+	o.Close()
+	err = o.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Write([]byte{5, 6, 7, 8})
+	o.Close()
+	if o.sink.(*wavSink).file != nil {
+		t.Fatal()
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != wavHeaderSize+8 ||
+		!bytes.Equal(data[:wavHeaderSize], wavHeader(44100, 2, 8)) ||
+		!bytes.Equal(data[wavHeaderSize:], []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Fatalf("unexpected file contents %v", data)
+	}
+	if binary.LittleEndian.Uint32(data[24:]) != 44100 ||
+		binary.LittleEndian.Uint32(data[28:]) != 176400 {
+		t.Fatal()
+	}
+
+	// Format change starts new file.
+	o.Open()
+	o.SetSampleRate(48000)
+	o.Write([]byte{9, 10, 11, 12})
+	data, _ = ioutil.ReadFile(file)
+	if len(data) != wavHeaderSize+4 ||
+		binary.LittleEndian.Uint32(data[24:]) != 48000 {
+		t.Fatal()
+	}
+}
+
+func TestPipe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o := NewPipe(filepath.Join(dir, "fifo"))
+	err = o.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	// Writes do not block without reader even if FIFO is full.
+	for i := 0; i < 2; i++ {
+		o.Reset()
+		n, err := o.Write(make([]byte, 128*1024))
+		if err != nil || n == 0 {
+			t.Fatal(err)
+		}
+	}
+
+	// Reader connected later receives fresh data only.
+	fd, err := syscall.Open(filepath.Join(dir, "fifo"),
+		syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Reset()
+	_, err = o.Write([]byte{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := syscall.Read(fd, buf)
+	if err != nil || !bytes.Equal(buf[:n], []byte{1, 2, 3, 4}) {
+		t.Fatalf("unexpected data %v: %v", buf[:n], err)
+	}
+	// Reader disconnection is not an error.
+	syscall.Close(fd)
+	o.Reset()
+	_, err = o.Write([]byte{5, 6, 7, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewPipe(filepath.Join(dir)).Open(); err == nil {
+		t.Fatal()
+	}
+}
+
+func TestPipeFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "fifo")
+	s := &pipeSink{path: file, fd: -1}
+	err = s.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	fd, err := syscall.Open(file, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	// 4096 bytes buffer can't keep whole number of 6 bytes frames.
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd),
+		syscall.F_SETPIPE_SZ, 4096)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+
+	// Three channels.
+	const frame = 6
+	buf := make([]byte, 1000*frame)
+	for i := range buf {
+		buf[i] = byte(i % frame)
+	}
+	err = s.write(buf, 44100, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 8192)
+	n, err := syscall.Read(fd, data)
+	if err != nil || n != 4096 {
+		t.Fatalf("4096 bytes expected but %d got: %v", n, err)
+	}
+	// The rest of the last frame is written before the next one.
+	err = s.write([]byte{10, 11, 12, 13, 14, 15}, 44100, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := syscall.Read(fd, data[n:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = data[:n+m]
+	if len(data)%frame != 0 ||
+		!bytes.Equal(data[len(data)-frame:], []byte{10, 11, 12, 13, 14, 15}) {
+		t.Fatalf("unexpected data length %d", len(data))
+	}
+	for i, b := range data[:len(data)-frame] {
+		if b != byte(i%frame) {
+			t.Fatalf("unexpected byte %d at %d", b, i)
+		}
+	}
+}
+
+type testEncoder struct{}
+
+func (testEncoder) MIME() string {
+	return "audio/test"
+}
+
+func (testEncoder) Header() []byte {
+	return []byte("HDR")
+}
+
+func (testEncoder) Encode(buf []byte, rate int, channels int) ([]byte, error) {
+	return buf, nil
+}
+
+func (testEncoder) Close() {
+}
+
+func TestHTTP(t *testing.T) {
+	s := &httpSink{enc: testEncoder{}}
+	s.setTrack(&vfs.Track{Tag: &vfs.Tag{Artist: "Doro", Title: "Fight"}})
+	srvConn, conn := net.Pipe()
+	c := newStreamClient(srvConn, s)
+	go c.Serve()
+	defer c.Close()
+
+	_, err := conn.Write([]byte("GET / HTTP/1.0\r\nIcy-MetaData: 1\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 ||
+		resp.Header.Get("Content-Type") != "audio/test" ||
+		resp.Header.Get("icy-metaint") != "16000" {
+		t.Fatalf("unexpected response %v", resp)
+	}
+
+	hdr := make([]byte, 3)
+	if _, err := io.ReadFull(r, hdr); err != nil || string(hdr) != "HDR" {
+		t.Fatal()
+	}
+	// Client is ready for data right after the header is sent.
+	for {
+		c.mu.Lock()
+		ready := c.ready
+		c.mu.Unlock()
+		if ready {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.send(make([]byte, icyMetaInt-3+10))
+
+	data := make([]byte, icyMetaInt-3)
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatal(err)
+	}
+	n, err := r.ReadByte()
+	if err != nil || n != 2 {
+		t.Fatal(n)
+	}
+	meta := make([]byte, int(n)*16)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		t.Fatal(err)
+	}
+	if string(bytes.TrimRight(meta, "\x00")) != "StreamTitle='Doro - Fight';" {
+		t.Fatalf("unexpected metadata %q", meta)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package output
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// pipeSink writes raw PCM data to the FIFO. FIFO is created if it does
+// not exist. Data is dropped if there is no reader or the reader is not
+// fast enough, so the player is never blocked by the reader.
+type pipeSink struct {
+	path string
+	// FIFO write end, -1 if there is no reader.
+	fd int
+	// Unwritten part of the partly written frame.
+	tail []byte
+}
+
+// NewPipe returns output which writes raw signed 16 bit little endian
+// PCM data to the FIFO.
+func NewPipe(path string) *Output {
+	return newOutput(&pipeSink{path: path, fd: -1})
+}
+
+func (p *pipeSink) open() error {
+	fi, err := os.Stat(p.path)
+	if os.IsNotExist(err) {
+		err = syscall.Mkfifo(p.path, 0644)
+		if err != nil {
+			return &os.PathError{Op: "mkfifo", Path: p.path, Err: err}
+		}
+	} else if err != nil {
+		return err
+	} else if fi.Mode()&os.ModeNamedPipe == 0 {
+		return &os.PathError{Op: "open", Path: p.path,
+			Err: errors.New("not a FIFO")}
+	}
+
+	return p.connect()
+}
+
+// connect opens FIFO for writing if there is a reader. FIFO is not
+// opened for reading by the sink itself, so a reader connected later
+// does not receive stale data.
+func (p *pipeSink) connect() error {
+	fd, err := syscall.Open(p.path,
+		syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENXIO {
+		// No reader, try again on the next write.
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "open", Path: p.path, Err: err}
+	}
+	p.fd = fd
+
+	return nil
+}
+
+func (p *pipeSink) write(buf []byte, rate int, channels int) error {
+	if p.fd == -1 {
+		err := p.connect()
+		if err != nil || p.fd == -1 {
+			return err
+		}
+	}
+	if len(p.tail) > 0 {
+		n, err := p.writeFIFO(p.tail)
+		if err != nil || p.fd == -1 {
+			return err
+		}
+		p.tail = p.tail[n:]
+		if len(p.tail) > 0 {
+			// FIFO is still full, drop new data.
+			return nil
+		}
+	}
+
+	n, err := p.writeFIFO(buf)
+	if err != nil || p.fd == -1 {
+		return err
+	}
+	// Only whole frames are dropped, so reader stays aligned
+	// to frames. The rest of the partly written frame is written
+	// before the next data.
+	frame := channels * sampleSize
+	if r := n % frame; n < len(buf) && r != 0 {
+		p.tail = append(p.tail[:0], buf[n:n+frame-r]...)
+	}
+
+	return nil
+}
+
+// writeFIFO writes buf to the FIFO until it is full and returns number
+// of written bytes. FIFO is closed if the reader is gone.
+func (p *pipeSink) writeFIFO(buf []byte) (int, error) {
+	written := 0
+	for written < len(buf) {
+		n, err := syscall.Write(p.fd, buf[written:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			// FIFO is full.
+			break
+		}
+		if err == syscall.EPIPE {
+			// Reader is gone.
+			p.close()
+			break
+		}
+		if err != nil {
+			return written, &os.PathError{Op: "write", Path: p.path,
+				Err: err}
+		}
+		written += n
+	}
+
+	return written, nil
+}
+
+func (p *pipeSink) close() {
+	if p.fd != -1 {
+		syscall.Close(p.fd)
+		p.fd = -1
+	}
+	// The next reader starts from a frame boundary.
+	p.tail = nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package output
+
+import "os"
+
+// stdoutSink writes raw PCM data to the standard output. Unlike FIFO
+// output, a slow reader blocks the player.
+type stdoutSink struct{}
+
+// NewStdout returns output which writes raw signed 16 bit little endian
+// PCM data to the standard output.
+func NewStdout() *Output {
+	return newOutput(stdoutSink{})
+}
+
+func (stdoutSink) open() error {
+	return nil
+}
+
+func (stdoutSink) write(buf []byte, rate int, channels int) error {
+	_, err := os.Stdout.Write(buf)
+
+	return err
+}
+
+func (stdoutSink) close() {
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package output
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+)
+
+const (
+	// WAV header size.
+	wavHeaderSize = 44
+	// Maximum data chunk size. Header keeps RIFF chunk size
+	// in 32 bits.
+	maxWavSize = math.MaxUint32 - (wavHeaderSize - 8)
+)
+
+var errWavFull = errors.New("WAV file size limit is reached")
+
+// wavSink writes data to WAV file. File is recreated when the output
+// is opened the first time and every time PCM format changes, all
+// other data is appended. Header is kept up to date after every write,
+// so file is valid even if the player is killed. Writing fails when
+// file reaches 4 GiB WAV size limit.
+type wavSink struct {
+	path     string
+	file     *os.File
+	rate     int
+	channels int
+	// Size of the data chunk, -1 if header is not written yet.
+	size int64
+}
+
+// NewWav returns output which writes data to the WAV file.
+func NewWav(path string) *Output {
+	return newOutput(&wavSink{path: path, size: -1})
+}
+
+func (w *wavSink) open() error {
+	if w.file != nil {
+		return nil
+	}
+	// File is truncated by the first write, so data is appended
+	// after reopening.
+	f, err := os.OpenFile(w.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+
+	return nil
+}
+
+func (w *wavSink) write(buf []byte, rate int, channels int) error {
+	if w.size < 0 || w.rate != rate || w.channels != channels {
+		err := w.file.Truncate(0)
+		if err != nil {
+			return err
+		}
+		w.rate = rate
+		w.channels = channels
+		w.size = 0
+	}
+
+	full := false
+	if left := maxWavSize - w.size; int64(len(buf)) > left {
+		buf = buf[:left-left%int64(channels*sampleSize)]
+		full = true
+	}
+	if len(buf) > 0 {
+		_, err := w.file.WriteAt(buf, wavHeaderSize+w.size)
+		if err != nil {
+			return err
+		}
+		w.size += int64(len(buf))
+		_, err = w.file.WriteAt(wavHeader(w.rate, w.channels, w.size), 0)
+		if err != nil {
+			return err
+		}
+	}
+	if full {
+		return errWavFull
+	}
+
+	return nil
+}
+
+func (w *wavSink) close() {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+}
+
+// wavHeader returns header of signed 16 bit PCM WAV file.
+func wavHeader(rate int, channels int, size int64) []byte {
+	h := make([]byte, wavHeaderSize)
+	le := binary.LittleEndian
+
+	copy(h[0:], "RIFF")
+	le.PutUint32(h[4:], uint32(wavHeaderSize-8+size))
+	copy(h[8:], "WAVE")
+	copy(h[12:], "fmt ")
+	le.PutUint32(h[16:], 16)
+	// PCM format.
+	le.PutUint16(h[20:], 1)
+	le.PutUint16(h[22:], uint16(channels))
+	le.PutUint32(h[24:], uint32(rate))
+	le.PutUint32(h[28:], uint32(rate*channels*sampleSize))
+	le.PutUint16(h[32:], uint16(channels*sampleSize))
+	le.PutUint16(h[34:], sampleSize*8)
+	copy(h[36:], "data")
+	le.PutUint32(h[40:], uint32(size))
+
+	return h
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Maximum volume level value.
+const MaxVolume = 100
+
+// Mixer interface represents volume control (software, ALSA, ...).
+type Mixer interface {
+	// Volume returns current volume level in 0-MaxVolume range.
+	Volume() (int, error)
+	// SetVolume sets new volume level in 0-MaxVolume range.
+	SetVolume(vol int) error
+}
+
+// SoftMixer is a software mixer implementation, which scales decoded
+// PCM data before it is written to the output.
+type SoftMixer struct {
+	mu     sync.Mutex
+	volume int
+}
+
+// NewSoftMixer returns new software mixer with maximum volume level set.
+func NewSoftMixer() *SoftMixer {
+	return &SoftMixer{volume: MaxVolume}
+}
+
+func (m *SoftMixer) Volume() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.volume, nil
+}
+
+func (m *SoftMixer) SetVolume(vol int) error {
+	m.mu.Lock()
+	m.volume = vol
+	m.mu.Unlock()
+
+	return nil
+}
+
+// apply scales signed 16 bit little endian PCM samples in buf
+// in accordance with current volume level.
+func (m *SoftMixer) apply(buf []byte) {
+	vol, _ := m.Volume()
+	if vol == MaxVolume {
+		return
+	}
+
+	for i := 0; i+1 < len(buf); i += 2 {
+		s := int32(int16(binary.LittleEndian.Uint16(buf[i:])))
+		s = s * int32(vol) / MaxVolume
+		binary.LittleEndian.PutUint16(buf[i:], uint16(int16(s)))
+	}
+}
+
+// fadeMix mixes signed 16 bit little endian PCM samples of the next
+// track (src) into the current track ones (dst). The current track is
+// faded out and the next one is faded in linearly over length bytes.
+// pos is the number of bytes mixed before.
+func fadeMix(dst []byte, src []byte, pos int, length int) {
+	l := int64(length)
+	for i := 0; i+1 < len(dst) && i+1 < len(src); i += 2 {
+		p := int64(pos + i)
+		if p > l {
+			p = l
+		}
+		a := int64(int16(binary.LittleEndian.Uint16(dst[i:])))
+		b := int64(int16(binary.LittleEndian.Uint16(src[i:])))
+		s := (a*(l-p) + b*p) / l
+		binary.LittleEndian.PutUint16(dst[i:], uint16(int16(s)))
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import "testing"
+
+func TestSoftMixer(t *testing.T) {
+	m := NewSoftMixer()
+	// 1000, -1000, 32767 samples.
+	buf := []byte{0xe8, 0x03, 0x18, 0xfc, 0xff, 0x7f}
+
+	m.apply(buf)
+	if buf[0] != 0xe8 || buf[1] != 0x03 {
+		t.Fatal()
+	}
+
+	m.SetVolume(50)
+	if v, err := m.Volume(); v != 50 || err != nil {
+		t.Fatal()
+	}
+	m.apply(buf)
+	// 500, -500, 16383 samples expected.
+	expected := []byte{0xf4, 0x01, 0x0c, 0xfe, 0xff, 0x3f}
+	for i := range expected {
+		if buf[i] != expected[i] {
+			t.Fatalf("%x expected but %x got", expected, buf)
+		}
+	}
+}
+
+func TestFadeMix(t *testing.T) {
+	// 1000, 1000, 1000, 1000 samples.
+	dst := []byte{0xe8, 0x03, 0xe8, 0x03, 0xe8, 0x03, 0xe8, 0x03}
+	// 0, 2000, -1000, 0 samples.
+	src := []byte{0x00, 0x00, 0xd0, 0x07, 0x18, 0xfc, 0x00, 0x00}
+
+	fadeMix(dst, src, 4, 8)
+	// 500, 1750, -1000, 0 samples expected.
+	expected := []byte{0xf4, 0x01, 0xd6, 0x06, 0x18, 0xfc, 0x00, 0x00}
+	for i := range expected {
+		if dst[i] != expected[i] {
+			t.Fatalf("%x expected but %x got", expected, dst)
+		}
+	}
+}
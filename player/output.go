@@ -17,6 +17,8 @@
 
 package player
 
+import "github.com/vchimishuk/chub/vfs"
+
 // Output interface represents audio autput driver (ALSA, OSS, ...).
 type Output interface {
 	// Open opens output audio device.
@@ -41,6 +43,8 @@ type Output interface {
 	Write(buf []byte) (written int, err error)
 	// Reset empties ouput buffer.
 	Reset()
+	// Drain waits until all buffered data is played.
+	Drain()
 	// Pause pauses or resumes playback process.
 	Pause()
 	// Paused returns true if output driver is in paused state now.
@@ -48,3 +52,11 @@ type Output interface {
 	// Close closes output audio device.
 	Close()
 }
+
+// TrackOutput is an optional interface implemented by outputs which
+// need to know the track being played, e.g. to send its title to
+// stream listeners.
+type TrackOutput interface {
+	// SetTrack is called when playback of the track starts.
+	SetTrack(track *vfs.Track)
+}
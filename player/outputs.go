@@ -0,0 +1,305 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import (
+	"errors"
+	"time"
+
+	"github.com/vchimishuk/chub/logger"
+	"github.com/vchimishuk/chub/vfs"
+)
+
+// NamedOutput is an output driver with the name it is shown to
+// clients under.
+type NamedOutput struct {
+	Name   string
+	Output Output
+	// Enabled is the initial output state.
+	Enabled bool
+}
+
+// OutputInfo describes one of player's outputs.
+type OutputInfo struct {
+	// Output number to enable or disable it by.
+	ID      int
+	Name    string
+	Enabled bool
+}
+
+// multiOutput is an Output which sends data to all enabled outputs.
+// Output accepts data only when every enabled output is ready
+// to accept it, so the slowest output defines the pace. Output which
+// fails is closed and skipped till the next open.
+type multiOutput struct {
+	outputs []*NamedOutput
+	open    bool
+	paused  bool
+	// PCM parameters all enabled outputs are set to.
+	rate     int
+	channels int
+}
+
+func newMultiOutput(outputs []NamedOutput) *multiOutput {
+	m := &multiOutput{}
+	for i := range outputs {
+		o := outputs[i]
+		m.outputs = append(m.outputs, &o)
+	}
+
+	return m
+}
+
+func (m *multiOutput) Open() error {
+	var err error
+	for _, o := range m.outputs {
+		if !o.Enabled {
+			continue
+		}
+		e := o.Output.Open()
+		if e != nil {
+			logger.Error("output %s: %s", o.Name, e)
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		if !m.open {
+			m.open = true
+			m.rate = o.Output.SampleRate()
+			m.channels = o.Output.Channels()
+		} else {
+			m.setParams(o.Output)
+		}
+	}
+	if !m.open {
+		if err == nil {
+			err = errors.New("no outputs enabled")
+		}
+		return err
+	}
+	m.paused = false
+
+	return nil
+}
+
+func (m *multiOutput) IsOpen() bool {
+	return m.open
+}
+
+func (m *multiOutput) SampleRate() int {
+	return m.rate
+}
+
+func (m *multiOutput) SetSampleRate(rate int) {
+	m.rate = rate
+	for _, o := range m.active() {
+		o.Output.SetSampleRate(rate)
+	}
+}
+
+func (m *multiOutput) Channels() int {
+	return m.channels
+}
+
+func (m *multiOutput) SetChannels(channels int) {
+	m.channels = channels
+	for _, o := range m.active() {
+		o.Output.SetChannels(channels)
+	}
+}
+
+func (m *multiOutput) Wait(maxDelay int) (ok bool, err error) {
+	deadline := time.Now().Add(time.Duration(maxDelay) * time.Millisecond)
+	for _, o := range m.active() {
+		d := int(time.Until(deadline) / time.Millisecond)
+		if d < 0 {
+			d = 0
+		}
+		ok, err := o.Output.Wait(d)
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+
+	return true, nil
+}
+
+func (m *multiOutput) AvailUpdate() (size int, err error) {
+	size = -1
+	for _, o := range m.active() {
+		s, err := o.Output.AvailUpdate()
+		if err != nil {
+			if e := m.fail(o, err); e != nil {
+				return 0, e
+			}
+			continue
+		}
+		if size == -1 || s < size {
+			size = s
+		}
+	}
+	if size == -1 {
+		size = 0
+	}
+
+	return size, nil
+}
+
+// Write writes the whole buffer to every enabled output.
+func (m *multiOutput) Write(buf []byte) (written int, err error) {
+	for _, o := range m.active() {
+		err := writeAll(o.Output, buf)
+		if err != nil {
+			if e := m.fail(o, err); e != nil {
+				return 0, e
+			}
+		}
+	}
+
+	return len(buf), nil
+}
+
+func (m *multiOutput) Reset() {
+	for _, o := range m.active() {
+		o.Output.Reset()
+	}
+}
+
+func (m *multiOutput) Drain() {
+	for _, o := range m.active() {
+		o.Output.Drain()
+	}
+}
+
+func (m *multiOutput) Pause() {
+	m.paused = !m.paused
+	for _, o := range m.active() {
+		if o.Output.Paused() != m.paused {
+			o.Output.Pause()
+		}
+	}
+}
+
+func (m *multiOutput) Paused() bool {
+	return m.paused
+}
+
+func (m *multiOutput) Close() {
+	for _, o := range m.active() {
+		o.Output.Close()
+	}
+	m.open = false
+}
+
+// SetTrack passes the track to all outputs which implement
+// TrackOutput interface.
+func (m *multiOutput) SetTrack(track *vfs.Track) {
+	for _, o := range m.outputs {
+		if t, ok := o.Output.(TrackOutput); ok {
+			t.SetTrack(track)
+		}
+	}
+}
+
+// Outputs returns all outputs description.
+func (m *multiOutput) Outputs() []*OutputInfo {
+	infos := make([]*OutputInfo, 0, len(m.outputs))
+	for i, o := range m.outputs {
+		infos = append(infos, &OutputInfo{ID: i, Name: o.Name,
+			Enabled: o.Enabled})
+	}
+
+	return infos
+}
+
+// Enable enables or disables output. Enabled output is opened
+// immediately if playback is active, disabled one is closed.
+// The last enabled output can't be disabled.
+func (m *multiOutput) Enable(id int, enabled bool) error {
+	if id < 0 || id >= len(m.outputs) {
+		return errors.New("invalid output")
+	}
+	o := m.outputs[id]
+	if o.Enabled == enabled {
+		return nil
+	}
+
+	if enabled {
+		if m.open {
+			err := o.Output.Open()
+			if err != nil {
+				return err
+			}
+			m.setParams(o.Output)
+			if m.paused {
+				o.Output.Pause()
+			}
+		}
+	} else {
+		n := 0
+		for _, oo := range m.outputs {
+			if oo.Enabled {
+				n++
+			}
+		}
+		if n == 1 {
+			return errors.New("the last enabled output")
+		}
+		if o.Output.IsOpen() {
+			o.Output.Reset()
+			o.Output.Close()
+		}
+	}
+	o.Enabled = enabled
+
+	return nil
+}
+
+// active returns enabled and open outputs.
+func (m *multiOutput) active() []*NamedOutput {
+	var outs []*NamedOutput
+	for _, o := range m.outputs {
+		if o.Enabled && o.Output.IsOpen() {
+			outs = append(outs, o)
+		}
+	}
+
+	return outs
+}
+
+// fail closes failed output. Error is returned if there are no more
+// open outputs.
+func (m *multiOutput) fail(o *NamedOutput, err error) error {
+	logger.Error("output %s: %s", o.Name, err)
+	o.Output.Close()
+	if len(m.active()) == 0 {
+		return err
+	}
+
+	return nil
+}
+
+func (m *multiOutput) setParams(o Output) {
+	if o.SampleRate() != m.rate {
+		o.SetSampleRate(m.rate)
+	}
+	if o.Channels() != m.channels {
+		o.SetChannels(m.channels)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import (
+	"errors"
+	"testing"
+)
+
+type testOutput struct {
+	// Number of Open calls.
+	opens    int
+	open     bool
+	paused   bool
+	rate     int
+	channels int
+	avail    int
+	written  int
+	err      error
+}
+
+func (o *testOutput) Open() error {
+	o.opens++
+	o.open = true
+	o.rate = 44100
+	o.channels = 2
+	return nil
+}
+
+func (o *testOutput) IsOpen() bool                    { return o.open }
+func (o *testOutput) SampleRate() int                 { return o.rate }
+func (o *testOutput) SetSampleRate(rate int)          { o.rate = rate }
+func (o *testOutput) Channels() int                   { return o.channels }
+func (o *testOutput) SetChannels(channels int)        { o.channels = channels }
+func (o *testOutput) Wait(maxDelay int) (bool, error) { return true, nil }
+func (o *testOutput) AvailUpdate() (int, error)       { return o.avail, o.err }
+func (o *testOutput) Reset()                          {}
+func (o *testOutput) Drain()                          {}
+func (o *testOutput) Pause()                          { o.paused = !o.paused }
+func (o *testOutput) Paused() bool                    { return o.paused }
+func (o *testOutput) Close()                          { o.open = false }
+
+func (o *testOutput) Write(buf []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	o.written += len(buf)
+	return len(buf), nil
+}
+
+func TestMultiOutput(t *testing.T) {
+	a := &testOutput{avail: 100}
+	b := &testOutput{avail: 50}
+	m := newMultiOutput([]NamedOutput{
+		{Name: "a", Output: a, Enabled: true},
+		{Name: "b", Output: b},
+	})
+
+	err := m.Open()
+	if err != nil || !a.open || b.open {
+		t.Fatal()
+	}
+	m.SetSampleRate(48000)
+	m.Pause()
+
+	err = m.Enable(1, true)
+	if err != nil || !b.open || b.rate != 48000 || !b.paused {
+		t.Fatal()
+	}
+	m.Pause()
+	if a.paused || b.paused {
+		t.Fatal()
+	}
+	if size, _ := m.AvailUpdate(); size != 50 {
+		t.Fatal(size)
+	}
+	m.Write(make([]byte, 10))
+	if a.written != 10 || b.written != 10 {
+		t.Fatal()
+	}
+
+	err = m.Enable(0, false)
+	if err != nil || a.open {
+		t.Fatal()
+	}
+	if m.Enable(1, false) == nil || m.Enable(2, true) == nil {
+		t.Fatal()
+	}
+	infos := m.Outputs()
+	if len(infos) != 2 || infos[0].Enabled || !infos[1].Enabled ||
+		infos[1].Name != "b" {
+		t.Fatal()
+	}
+
+	// Failed output is closed, but error is returned only when
+	// there are no more outputs left.
+	m.Enable(0, true)
+	a.err = errors.New("failure")
+	if _, err := m.Write(make([]byte, 10)); err != nil || a.open {
+		t.Fatal()
+	}
+	b.err = a.err
+	if _, err := m.Write(make([]byte, 10)); err == nil {
+		t.Fatal()
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sync"
 
 	"github.com/vchimishuk/chub/format"
+	"github.com/vchimishuk/chub/logger"
 	"github.com/vchimishuk/chub/vfs"
 )
 
@@ -32,43 +33,126 @@ const (
 type Event string
 
 const (
-	EventStatus Event = "status"
-	EventVolume Event = "volume"
+	EventStatus    Event = "status"
+	EventVolume    Event = "volume"
+	EventPlaylist  Event = "playlist"
+	EventPlaylists Event = "playlists"
+	// Track failed to play. Argument is *PlayError.
+	EventError Event = "error"
 )
 
+// Playlist and playlists change actions.
+const (
+	ActionCreated  = "created"
+	ActionDeleted  = "deleted"
+	ActionRenamed  = "renamed"
+	ActionAppended = "appended"
+	ActionRemoved  = "removed"
+	ActionMoved    = "moved"
+	ActionCleared  = "cleared"
+	ActionReplaced = "replaced"
+)
+
+// PlaylistsChange describes change of the user playlists list.
+// It is EventPlaylists event argument.
+type PlaylistsChange struct {
+	// One of ActionCreated, ActionDeleted or ActionRenamed.
+	Action string
+	// Playlist name.
+	Name string
+	// New playlist name for ActionRenamed.
+	NewName string
+}
+
+// PlaylistChange describes change of tracks in a playlist.
+// It is EventPlaylist event argument.
+type PlaylistChange struct {
+	// One of ActionAppended, ActionRemoved, ActionMoved,
+	// ActionCleared or ActionReplaced.
+	Action string
+	// Playlist name.
+	Name string
+	// Range of removed, moved or replaced tracks (both inclusive).
+	From int
+	To   int
+	// Position tracks were appended or moved to.
+	Pos int
+	// Appended tracks or new tracks of the replaced range.
+	Tracks []*vfs.Track
+}
+
 type EventHandler func(e Event, args []interface{})
 
+type event struct {
+	e    Event
+	args []interface{}
+}
+
 type Player struct {
 	// Mutex guards plists and curPlist fields.
 	// Any manipulation on that fields must be guarded with this mutex.
 	plistsMu sync.RWMutex
 	plists   map[string]*Playlist
 	curPlist *Playlist
-	// Used output driver.
-	output Output
+	// Used volume control.
+	mixer Mixer
+	// Mutex serializes volume changes.
+	volumeMu sync.Mutex
 	// Playing thread, which manages decode-output loop.
-	pt           *playingThread
-	eventHandler EventHandler
+	pt *playingThread
+	// Mutex guards eventHandlers field.
+	handlersMu    sync.Mutex
+	eventHandlers []EventHandler
+	// Events queue, so handler receives them in order they happen.
+	events chan *event
+	// Mutex guards pending field.
+	pendingMu sync.Mutex
+	// Events waiting to be sent to events queue.
+	pending []*event
+	// Mutex serializes flush calls, so pending events are sent
+	// in order.
+	flushMu sync.Mutex
 }
 
-func New(fmts []format.Format, output Output) *Player {
+// New returns new player which plays sound to all enabled outputs.
+func New(fmts []format.Format, outputs []NamedOutput, mixer Mixer) *Player {
 	p := &Player{
 		plists:   make(map[string]*Playlist),
 		curPlist: NewPlaylist(vfsPlistName),
-		output:   output,
-		pt:       newPlayingThread(fmts, output),
+		mixer:    mixer,
+		pt:       newPlayingThread(fmts, newMultiOutput(outputs), mixer),
+		events:   make(chan *event, 64),
 	}
+	go p.dispatch()
+	// Status and error events are queued by the playing thread
+	// worker, so clients receive them in the order they happen.
+	// Flush can block on the full events queue, so it is done in
+	// background.
 	p.pt.SetStatusHandler(func(s *Status) {
-		p.notify(EventStatus, s)
+		s.Volume, _ = p.Volume()
+		p.queue(EventStatus, s)
+		go p.flush()
+	})
+	p.pt.SetConsumeHandler(p.consume)
+	p.pt.SetErrorHandler(func(e *PlayError) {
+		logger.Warning("failed to play %s", e)
+		p.queue(EventError, e)
+		go p.flush()
 	})
+	// Playlist is set before the worker is started, so it can't
+	// override playlist of the first Play or Resume call.
+	p.pt.setPlaylist(p.curPlist)
 	p.pt.Start()
-	p.pt.SetPlaylist(p.curPlist)
 
 	return p
 }
 
-func (p *Player) SetEventHandler(h EventHandler) {
-	p.eventHandler = h
+// AddEventHandler adds handler to be called for every event.
+// Handlers are called one by one in the order they were added.
+func (p *Player) AddEventHandler(h EventHandler) {
+	p.handlersMu.Lock()
+	p.eventHandlers = append(p.eventHandlers, h)
+	p.handlersMu.Unlock()
 }
 
 func (p *Player) Close() {
@@ -123,6 +207,103 @@ func (p *Player) Pause() {
 	p.pt.Pause()
 }
 
+// SetPaused pauses (paused is true) or resumes playback.
+func (p *Player) SetPaused(paused bool) {
+	p.pt.SetPaused(paused)
+}
+
+// Repeat toggles between repeat and off modes.
+func (p *Player) Repeat() {
+	p.pt.Repeat()
+}
+
+// SetMode sets playback mode. Playback started after the call
+// is in the new mode.
+func (p *Player) SetMode(mode Mode) {
+	p.pt.SetMode(mode)
+}
+
+// SetCrossfade sets duration of crossfade between tracks in seconds.
+// 0 disables crossfade.
+func (p *Player) SetCrossfade(sec int) error {
+	if sec < 0 {
+		return errors.New("invalid duration")
+	}
+	p.pt.SetCrossfade(sec)
+
+	return nil
+}
+
+// Outputs returns all outputs description.
+func (p *Player) Outputs() []*OutputInfo {
+	return p.pt.Outputs()
+}
+
+// EnableOutput enables (enabled is true) or disables output by its ID.
+// Playback is not interrupted.
+func (p *Player) EnableOutput(id int, enabled bool) error {
+	return p.pt.EnableOutput(id, enabled)
+}
+
+// SetReplayGain sets ReplayGain mode.
+func (p *Player) SetReplayGain(mode ReplayGainMode) {
+	p.pt.SetReplayGain(mode)
+}
+
+// SetOutputFormat makes player convert all tracks to the given sample
+// rate and number of channels with resamplers f returns, so output is
+// never reopened because of the format change. rate 0 makes player play
+// tracks in their own format. The format is applied to the next played
+// track. SetOutputFormat returns after the format is set, so tracks
+// resumed afterwards are already converted.
+func (p *Player) SetOutputFormat(rate int, channels int, f ResamplerFunc) error {
+	if rate < 0 || channels < 0 || rate > 0 && (channels == 0 || f == nil) {
+		return errors.New("invalid format")
+	}
+	p.pt.SetOutputFormat(rate, channels, f)
+
+	return nil
+}
+
+// Seek sets playing track position. pos is a position in seconds from
+// the track beginning if rel is false or an offset from the current
+// position otherwise. Position is limited by the track boundaries.
+func (p *Player) Seek(pos int, rel bool) {
+	p.pt.Seek(pos, rel)
+}
+
+// Volume returns current volume level in 0-MaxVolume range.
+func (p *Player) Volume() (int, error) {
+	return p.mixer.Volume()
+}
+
+// SetVolume sets new volume level. If rel is true vol is added to the
+// current level. Result value is limited to 0-MaxVolume range.
+func (p *Player) SetVolume(vol int, rel bool) error {
+	p.volumeMu.Lock()
+	defer p.volumeMu.Unlock()
+
+	if rel {
+		cur, err := p.mixer.Volume()
+		if err != nil {
+			return err
+		}
+		vol += cur
+	}
+	if vol < 0 {
+		vol = 0
+	} else if vol > MaxVolume {
+		vol = MaxVolume
+	}
+	err := p.mixer.SetVolume(vol)
+	if err != nil {
+		return err
+	}
+	p.notify(EventVolume, vol)
+
+	return nil
+}
+
 func (p *Player) Next() {
 	p.pt.Next()
 }
@@ -133,6 +314,7 @@ func (p *Player) Prev() {
 
 func (p *Player) Append(name string, path *vfs.Path) error {
 	p.plistsMu.Lock()
+	defer p.flush()
 	defer p.plistsMu.Unlock()
 
 	pl, err := p.userPlist(name)
@@ -146,18 +328,116 @@ func (p *Player) Append(name string, path *vfs.Path) error {
 		return err
 	}
 	p.replace(name, pl.Append(tracks...))
+	p.queue(EventPlaylist, &PlaylistChange{
+		Action: ActionAppended,
+		Name:   name,
+		Pos:    pl.Len(),
+		Tracks: tracks,
+	})
+
+	return nil
+}
+
+// PlayPlaylist starts playing user playlist from the track at pos.
+func (p *Player) PlayPlaylist(name string, pos int) error {
+	p.plistsMu.Lock()
+	defer p.plistsMu.Unlock()
+
+	pl, err := p.userPlist(name)
+	if err != nil {
+		return err
+	}
+	if pos < 0 || pos >= pl.Len() {
+		return errors.New("invalid position")
+	}
+
+	p.curPlist = pl
+	p.pt.Play(pl, pos)
+
+	return nil
+}
+
+// Resume restores playback of the playlist from the track at pos and
+// offset seconds inside it. Playback is paused if paused is true.
+// plist must be either one of user playlists or VFS playlist.
+func (p *Player) Resume(plist *Playlist, pos int, offset int, paused bool) error {
+	p.plistsMu.Lock()
+	defer p.plistsMu.Unlock()
+
+	if plist.Name() != vfsPlistName {
+		pl, err := p.userPlist(plist.Name())
+		if err != nil {
+			return err
+		}
+		plist = pl
+	}
+	if pos < 0 || pos >= plist.Len() {
+		return errors.New("invalid position")
+	}
+
+	p.curPlist = plist
+	p.pt.Resume(plist, pos, offset, paused)
+
+	return nil
+}
+
+// Remove removes tracks in from-to range (both inclusive)
+// from the playlist.
+func (p *Player) Remove(name string, from int, to int) error {
+	p.plistsMu.Lock()
+	defer p.flush()
+	defer p.plistsMu.Unlock()
 
-	// TODO: Add new tracks parameter to notification,
-	//       so client can update his playlist version
-	//       without requesting new version.
-	//       The same for other similar functions.
-	// go p.notify(PlaylistEvent, plist)
+	pl, err := p.userPlist(name)
+	if err != nil {
+		return err
+	}
+	if from < 0 || to < from || to >= pl.Len() {
+		return errors.New("invalid range")
+	}
+	p.replace(name, pl.Remove(from, to))
+	p.queue(EventPlaylist, &PlaylistChange{
+		Action: ActionRemoved,
+		Name:   name,
+		From:   from,
+		To:     to,
+	})
+
+	return nil
+}
+
+// Move moves tracks in from-to range (both inclusive) to the pos
+// position. pos is the position in the playlist without moved tracks.
+func (p *Player) Move(name string, from int, to int, pos int) error {
+	p.plistsMu.Lock()
+	defer p.flush()
+	defer p.plistsMu.Unlock()
+
+	pl, err := p.userPlist(name)
+	if err != nil {
+		return err
+	}
+	if from < 0 || to < from || to >= pl.Len() {
+		return errors.New("invalid range")
+	}
+	if pos < 0 || pos > pl.Len()-(to-from+1) {
+		return errors.New("invalid position")
+	}
+	p.replace(name, pl.Move(from, to, pos))
+	p.queue(EventPlaylist, &PlaylistChange{
+		Action: ActionMoved,
+		Name:   name,
+		From:   from,
+		To:     to,
+		Pos:    pos,
+	})
 
 	return nil
 }
 
 func (p *Player) Clear(name string) error {
 	p.plistsMu.Lock()
+	defer p.flush()
 	defer p.plistsMu.Unlock()
 
 	pl, err := p.userPlist(name)
@@ -165,12 +445,17 @@ func (p *Player) Clear(name string) error {
 		return err
 	}
 	p.replace(name, pl.Clear())
+	p.queue(EventPlaylist, &PlaylistChange{
+		Action: ActionCleared,
+		Name:   name,
+	})
 
 	return nil
 }
 
 func (p *Player) Create(name string) error {
 	p.plistsMu.Lock()
+	defer p.flush()
 	defer p.plistsMu.Unlock()
 
 	_, err := p.userPlist(name)
@@ -178,15 +463,37 @@ func (p *Player) Create(name string) error {
 		return errors.New("already exists")
 	}
 
+	if name == vfsPlistName {
+		return errors.New("invalid playlist")
+	}
+
 	p.plists[name] = NewPlaylist(name)
+	p.queue(EventPlaylists, &PlaylistsChange{
+		Action: ActionCreated,
+		Name:   name,
+	})
+
+	return nil
+}
+
+// AddPlaylist adds already filled playlist to the user playlists list.
+func (p *Player) AddPlaylist(plist *Playlist) error {
+	p.plistsMu.Lock()
+	defer p.plistsMu.Unlock()
+
+	_, err := p.userPlist(plist.Name())
+	if err == nil || plist.Name() == vfsPlistName {
+		return errors.New("already exists")
+	}
 
-	// go p.notify(PlaylistsEvent, name)
+	p.plists[plist.Name()] = plist
 
 	return nil
 }
 
 func (p *Player) Delete(name string) error {
 	p.plistsMu.Lock()
+	defer p.flush()
 	defer p.plistsMu.Unlock()
 
 	pl, err := p.userPlist(name)
@@ -197,10 +504,12 @@ func (p *Player) Delete(name string) error {
 	delete(p.plists, name)
 	if pl.Name() == p.curPlist.Name() {
 		p.pt.Stop()
-		p.curPlist = nil
+		p.curPlist = NewPlaylist(vfsPlistName)
 	}
-
-	// TODO: go p.notify(PlaylistsEvent, p.Playlists())
+	p.queue(EventPlaylists, &PlaylistsChange{
+		Action: ActionDeleted,
+		Name:   name,
+	})
 
 	return nil
 }
@@ -219,20 +528,51 @@ func (p *Player) Playlist(name string) (*Playlist, error) {
 
 func (p *Player) Rename(from string, to string) error {
 	p.plistsMu.Lock()
+	defer p.flush()
 	defer p.plistsMu.Unlock()
 
 	pl, err := p.userPlist(from)
 	if err != nil {
 		return err
 	}
+	if _, ok := p.plists[to]; ok || to == vfsPlistName {
+		return errors.New("already exists")
+	}
 
 	p.replace(from, pl.SetName(to))
-
-	// go p.notify(PlaylistsEvent, p.Playlists())
+	p.queue(EventPlaylists, &PlaylistsChange{
+		Action:  ActionRenamed,
+		Name:    from,
+		NewName: to,
+	})
 
 	return nil
 }
 
+// ReplaceTracks replaces tracks in all playlists with the ones
+// tracks map maps them to, e.g. missing tracks with the found ones.
+func (p *Player) ReplaceTracks(tracks map[*vfs.Track]*vfs.Track) {
+	p.plistsMu.Lock()
+	defer p.flush()
+	defer p.plistsMu.Unlock()
+
+	cur := p.curPlist
+	for name, pl := range p.plists {
+		npl := p.replaceTracks(pl, tracks)
+		p.plists[name] = npl
+		if p.curPlist == pl {
+			p.curPlist = npl
+		}
+	}
+	if p.plists[p.curPlist.Name()] != p.curPlist {
+		// Not a user playlist.
+		p.curPlist = p.replaceTracks(p.curPlist, tracks)
+	}
+	if p.curPlist != cur {
+		p.pt.SetPlaylist(p.curPlist)
+	}
+}
+
 func (p *Player) Playlists() []*Playlist {
 	p.plistsMu.RLock()
 	defer p.plistsMu.RUnlock()
@@ -246,7 +586,10 @@ func (p *Player) Playlists() []*Playlist {
 }
 
 func (p *Player) Status() *Status {
-	return p.pt.Status()
+	s := p.pt.Status()
+	s.Volume, _ = p.Volume()
+
+	return s
 }
 
 func (p *Player) userPlist(name string) (*Playlist, error) {
@@ -272,9 +615,90 @@ func (p *Player) replace(name string, pl *Playlist) {
 	}
 }
 
+// replaceTracks returns playlist with tracks replaced according to
+// tracks map. The same playlist is returned if nothing is replaced.
+func (p *Player) replaceTracks(pl *Playlist, tracks map[*vfs.Track]*vfs.Track) *Playlist {
+	for i := 0; i < pl.Len(); i++ {
+		t, ok := tracks[pl.Get(i)]
+		if !ok {
+			continue
+		}
+		pl = pl.Replace(i, t)
+		p.queue(EventPlaylist, &PlaylistChange{
+			Action: ActionReplaced,
+			Name:   pl.Name(),
+			From:   i,
+			To:     i,
+			Tracks: []*vfs.Track{t},
+		})
+	}
+
+	return pl
+}
+
+// consume removes played track from the playlist in consume mode.
+func (p *Player) consume(plist *Playlist, pos int) {
+	p.plistsMu.Lock()
+	defer p.flush()
+	defer p.plistsMu.Unlock()
+
+	// Playlist has been already changed or replaced by user.
+	if p.curPlist != plist {
+		return
+	}
+
+	p.curPlist = plist.Remove(pos, pos)
+	if plist.Name() != vfsPlistName {
+		p.plists[plist.Name()] = p.curPlist
+	}
+	p.pt.SetPlaylist(p.curPlist)
+	p.queue(EventPlaylist, &PlaylistChange{
+		Action: ActionRemoved,
+		Name:   plist.Name(),
+		From:   pos,
+		To:     pos,
+	})
+}
+
+// notify sends event to the handler.
 func (p *Player) notify(e Event, args ...interface{}) {
-	if p.eventHandler != nil {
-		go p.eventHandler(e, args)
+	p.queue(e, args...)
+	p.flush()
+}
+
+// queue adds event to the pending list. Playlist changes queue events
+// while plistsMu is held, so events are in the same order as changes,
+// and flush them after the mutex is released, so a slow event handler
+// can't block playlist changes.
+func (p *Player) queue(e Event, args ...interface{}) {
+	p.pendingMu.Lock()
+	p.pending = append(p.pending, &event{e: e, args: args})
+	p.pendingMu.Unlock()
+}
+
+// flush sends pending events to the dispatcher.
+func (p *Player) flush() {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.pendingMu.Lock()
+	evs := p.pending
+	p.pending = nil
+	p.pendingMu.Unlock()
+	for _, ev := range evs {
+		p.events <- ev
+	}
+}
+
+// dispatch passes queued events to the handler one by one.
+func (p *Player) dispatch() {
+	for ev := range p.events {
+		p.handlersMu.Lock()
+		handlers := p.eventHandlers
+		p.handlersMu.Unlock()
+		for _, h := range handlers {
+			h(ev.e, ev.args)
+		}
 	}
 }
 
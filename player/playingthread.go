@@ -18,12 +18,16 @@
 package player
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/vchimishuk/chub/csync"
 	"github.com/vchimishuk/chub/format"
+	"github.com/vchimishuk/chub/vfs"
 )
 
 type State int
@@ -34,22 +38,95 @@ const (
 	StatePaused
 )
 
+// Mode defines the order tracks are played in.
+type Mode int
+
+const (
+	// Play tracks one by one and stop after the last one.
+	ModeOff Mode = iota
+	// Start playlist from the beginning after the last track.
+	ModeRepeat
+	// Play the current track over and over again.
+	ModeRepeatOne
+	// Play tracks in random order without repeats.
+	ModeRandom
+	// Play tracks one by one and remove played ones from the playlist.
+	ModeConsume
+)
+
+var modeNames = map[Mode]string{
+	ModeOff:       "off",
+	ModeRepeat:    "repeat",
+	ModeRepeatOne: "repeat-one",
+	ModeRandom:    "random",
+	ModeConsume:   "consume",
+}
+
+func (m Mode) String() string {
+	return modeNames[m]
+}
+
+// ParseMode returns Mode by its string name.
+func ParseMode(s string) (Mode, error) {
+	for m, name := range modeNames {
+		if name == s {
+			return m, nil
+		}
+	}
+
+	return ModeOff, errors.New("invalid mode")
+}
+
 type Status struct {
 	State    State
 	Plist    *Playlist
 	PlistPos int
 	Pos      int
+	Mode     Mode
+	Volume   int
+	// Crossfade duration in seconds, 0 if crossfade is disabled.
+	Crossfade int
+	// ReplayGain adjustment applied to tracks.
+	ReplayGain ReplayGainMode
+	// Description of the last playback error, empty if there was none.
+	LastError string
+}
+
+// PlayError describes failure to play a track.
+type PlayError struct {
+	// Path of the failed track.
+	Path string
+	Err  error
 }
 
+func (e *PlayError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+// Maximum number of failed tracks skipped in a row before playback
+// is stopped.
+const maxSkippedTracks = 16
+
+// Number of seconds before the end of the current track the next one
+// is opened at, so there is no gap between them.
+const preloadTime = 5
+
 type command int
 
 const (
 	cmdClose command = iota
+	cmdCrossfade
 	cmdNext
 	cmdPause
 	cmdPlay
+	cmdOutput
+	cmdOutputFormat
+	cmdOutputs
 	cmdPlist
 	cmdPrev
+	cmdMode
+	cmdReplayGain
+	cmdSeek
 	cmdStatus
 	cmdStop
 )
@@ -64,6 +141,10 @@ type playingThread struct {
 	fmts map[string]format.Format
 	// Active output.
 	output Output
+	// All outputs active output sends data to.
+	outputs *multiOutput
+	// Software mixer if used, nil otherwise.
+	softMixer *SoftMixer
 	// Active decoder.
 	decoder format.Decoder
 	// Active playlist.
@@ -74,13 +155,50 @@ type playingThread struct {
 	workerNotify *csync.Notify
 	// Current state.
 	state State
+	// Playback mode.
+	mode Mode
+	// Random mode tracks order and position of the current track in it.
+	order    []int
+	orderPos int
+	// Decoder of the next track opened in advance and its position
+	// in the active playlist. nextPos is -1 if nothing is opened yet.
+	// nextDecoder is nil if the next track continues the current
+	// file or it failed to open.
+	nextDecoder format.Decoder
+	nextPos     int
+	// Crossfade duration in seconds.
+	crossfade int
+	// Active crossfade progress and its total length in bytes.
+	// fadeLen is 0 if there is no crossfade in progress.
+	fadePos int
+	fadeLen int
+	// Buffer for the next track data mixed in during crossfade.
+	fadeBuf []byte
+	// ReplayGain mode and current and the next track samples scale.
+	replayGain ReplayGainMode
+	scale      float64
+	nextScale  float64
+	// Fixed output sample rate and number of channels all tracks
+	// are converted to with resamplers newResampler returns.
+	// outRate is 0 if tracks are played in their own format.
+	outRate      int
+	outChannels  int
+	newResampler ResamplerFunc
+	// Number of PREGAP and POSTGAP silence samples left to play
+	// before and after the current track data.
+	pregap  int64
+	postgap int64
 	// Channel to notify worker that output is ready to consume
 	// new portion of decoded data.
-	bufAvail      chan struct{}
-	statusHandler func(*Status)
+	bufAvail       chan struct{}
+	statusHandler  func(*Status)
+	consumeHandler func(plist *Playlist, pos int)
+	errorHandler   func(*PlayError)
+	// Last playback error description.
+	lastError string
 }
 
-func newPlayingThread(fmts []format.Format, output Output) *playingThread {
+func newPlayingThread(fmts []format.Format, outputs *multiOutput, mixer Mixer) *playingThread {
 	fm := map[string]format.Format{}
 	for _, f := range fmts {
 		for _, e := range f.Extensions() {
@@ -88,20 +206,41 @@ func newPlayingThread(fmts []format.Format, output Output) *playingThread {
 		}
 	}
 
+	sm, _ := mixer.(*SoftMixer)
+
 	return &playingThread{
 		fmts:         fm,
-		output:       output,
+		output:       outputs,
+		outputs:      outputs,
+		softMixer:    sm,
 		pos:          -1,
+		nextPos:      -1,
+		scale:        1,
 		workerNotify: csync.NewNotify(),
 		bufAvail:     make(chan struct{}),
 		state:        StateStopped,
 	}
 }
 
+// SetStatusHandler sets handler to be called on every status change.
+// Status and error handlers are called by the worker in the order
+// events happen, so they must not block or call playing thread.
 func (pt *playingThread) SetStatusHandler(h func(*Status)) {
 	pt.statusHandler = h
 }
 
+// SetConsumeHandler sets handler to be called with a track position
+// which has to be removed from the playlist in consume mode.
+func (pt *playingThread) SetConsumeHandler(h func(plist *Playlist, pos int)) {
+	pt.consumeHandler = h
+}
+
+// SetErrorHandler sets handler to be called on every track which failed
+// to play. See SetStatusHandler for restrictions.
+func (pt *playingThread) SetErrorHandler(h func(*PlayError)) {
+	pt.errorHandler = h
+}
+
 func (pt *playingThread) Start() {
 	go pt.worker()
 }
@@ -121,10 +260,60 @@ func (pt *playingThread) Play(plist *Playlist, pos int) {
 	pt.workerNotify.Send(msg)
 }
 
+// Resume starts playing the track at pos from offset seconds
+// and pauses playback immediately if paused is true.
+func (pt *playingThread) Resume(plist *Playlist, pos int, offset int, paused bool) {
+	msg := &message{cmd: cmdPlay,
+		args: []interface{}{plist, pos, offset, paused}}
+	pt.workerNotify.Send(msg)
+}
+
 func (pt *playingThread) Pause() {
 	pt.workerNotify.Send(&message{cmd: cmdPause})
 }
 
+func (pt *playingThread) SetPaused(paused bool) {
+	msg := &message{cmd: cmdPause, args: []interface{}{paused}}
+	pt.workerNotify.Send(msg)
+}
+
+// Repeat toggles between repeat and off modes.
+func (pt *playingThread) Repeat() {
+	<-pt.workerNotify.Send(&message{cmd: cmdMode})
+}
+
+// SetMode sets playback mode. It waits for the mode to be applied,
+// so commands sent after it are played in the new mode.
+func (pt *playingThread) SetMode(mode Mode) {
+	msg := &message{cmd: cmdMode, args: []interface{}{mode}}
+	<-pt.workerNotify.Send(msg)
+}
+
+// SetCrossfade sets crossfade duration in seconds. 0 disables crossfade.
+func (pt *playingThread) SetCrossfade(sec int) {
+	msg := &message{cmd: cmdCrossfade, args: []interface{}{sec}}
+	pt.workerNotify.Send(msg)
+}
+
+// SetReplayGain sets ReplayGain mode.
+func (pt *playingThread) SetReplayGain(mode ReplayGainMode) {
+	msg := &message{cmd: cmdReplayGain, args: []interface{}{mode}}
+	pt.workerNotify.Send(msg)
+}
+
+// SetOutputFormat sets fixed output format. It is applied to tracks
+// opened after the call. rate 0 disables conversion.
+func (pt *playingThread) SetOutputFormat(rate int, channels int, f ResamplerFunc) {
+	msg := &message{cmd: cmdOutputFormat,
+		args: []interface{}{rate, channels, f}}
+	<-pt.workerNotify.Send(msg)
+}
+
+func (pt *playingThread) Seek(pos int, rel bool) {
+	msg := &message{cmd: cmdSeek, args: []interface{}{pos, rel}}
+	pt.workerNotify.Send(msg)
+}
+
 func (pt *playingThread) Next() {
 	pt.workerNotify.Send(&message{cmd: cmdNext})
 }
@@ -138,6 +327,23 @@ func (pt *playingThread) SetPlaylist(plist *Playlist) {
 	pt.workerNotify.Send(msg)
 }
 
+// Outputs returns all outputs description.
+func (pt *playingThread) Outputs() []*OutputInfo {
+	r := <-pt.workerNotify.Send(&message{cmd: cmdOutputs})
+	return r.([]*OutputInfo)
+}
+
+// EnableOutput enables or disables output without playback interruption.
+func (pt *playingThread) EnableOutput(id int, enabled bool) error {
+	msg := &message{cmd: cmdOutput, args: []interface{}{id, enabled}}
+	err := <-pt.workerNotify.Send(msg)
+	if err != nil {
+		return err.(error)
+	}
+
+	return nil
+}
+
 func (pt *playingThread) Status() *Status {
 	s := <-pt.workerNotify.Send(&message{cmd: cmdStatus})
 	return s.(*Status)
@@ -166,6 +372,12 @@ func (pt *playingThread) worker() {
 			case cmdPlay:
 				pt.setPlaylist(msg.args[0].(*Playlist))
 				pt.play(msg.args[1].(int), false)
+				if len(msg.args) > 2 {
+					pt.seek(msg.args[2].(int), false)
+					if msg.args[3].(bool) {
+						pt.pause(true)
+					}
+				}
 			case cmdClose:
 				pt.stop()
 				quit = true
@@ -173,29 +385,52 @@ func (pt *playingThread) worker() {
 			case cmdStop:
 				pt.stop()
 			case cmdPause:
-				if pt.state == StatePlaying {
-					pt.output.Pause()
-					pt.stopBufAvailableChecker()
-					pt.state = StatePaused
-					pt.emitStatus()
-				} else if pt.state == StatePaused {
-					pt.output.Pause()
-					pt.startBufAvailableChecker()
-					pt.state = StatePlaying
-					pt.emitStatus()
+				paused := pt.state == StatePlaying
+				if len(msg.args) > 0 {
+					paused = msg.args[0].(bool)
 				}
+				pt.pause(paused)
 			case cmdNext, cmdPrev:
-				var pos int
-
-				if msg.cmd == cmdNext {
-					pos = pt.pos + 1
+				if pt.plist != nil && pt.plist.Len() > 0 {
+					if msg.cmd == cmdNext {
+						pos, _ := pt.next(false)
+						pt.play(pos, false)
+					} else {
+						pt.play(pt.prev(), false)
+					}
+				}
+			case cmdMode:
+				if len(msg.args) > 0 {
+					pt.setMode(msg.args[0].(Mode))
+				} else if pt.mode == ModeRepeat {
+					pt.setMode(ModeOff)
 				} else {
-					pos = pt.pos - 1
+					pt.setMode(ModeRepeat)
 				}
-
-				pt.play(pos, false)
+				pt.updateScale()
+				pt.emitStatus()
+				m.Result <- struct{}{}
+			case cmdReplayGain:
+				pt.replayGain = msg.args[0].(ReplayGainMode)
+				pt.updateScale()
+				pt.emitStatus()
+			case cmdOutputFormat:
+				pt.outRate = msg.args[0].(int)
+				pt.outChannels = msg.args[1].(int)
+				pt.newResampler = msg.args[2].(ResamplerFunc)
+				m.Result <- struct{}{}
+			case cmdCrossfade:
+				pt.crossfade = msg.args[0].(int)
+				pt.emitStatus()
+			case cmdSeek:
+				pt.seek(msg.args[0].(int), msg.args[1].(bool))
 			case cmdStatus:
 				m.Result <- pt.status()
+			case cmdOutputs:
+				m.Result <- pt.outputs.Outputs()
+			case cmdOutput:
+				m.Result <- pt.enableOutput(msg.args[0].(int),
+					msg.args[1].(bool))
 			default:
 				panic("unsupported command")
 			}
@@ -207,38 +442,34 @@ func (pt *playingThread) worker() {
 		if pt.state == StatePlaying {
 			size, err := pt.output.AvailUpdate()
 			if err != nil {
-				// TODO: Error handling.
-				panic(err)
+				pt.fail(err)
+				continue
 			}
 			if size > 0 {
 				if size > len(buf) {
 					size = len(buf)
 				}
 
-				cur := pt.plist.Get(pt.pos)
+				pt.preload()
 				read := 0
-
-				if !cur.Part || pt.decoder.Time() < cur.End {
-					var err error
-					read, err = pt.decoder.Read(buf[:size])
-					if err != nil {
-						// Ignore errors and treat them as
-						// end of the file.
-						read = 0
-					}
+				// Switch to the next track as soon as
+				// crossfade is finished.
+				if pt.fadeLen == 0 || pt.fadePos < pt.fadeLen {
+					read = pt.read(buf[:size])
+				}
+				if read > 0 {
+					applyGain(buf[:read], pt.scale)
+					pt.mixNext(buf[:read])
 				}
 				if read == 0 {
-					// TODO: Repeat support.
-					if pt.pos+1 < pt.plist.Len() {
-						pt.play(pt.pos+1, true)
-					} else {
-						pt.stop()
-					}
+					pt.trackEnd()
 				} else {
+					if pt.softMixer != nil {
+						pt.softMixer.apply(buf[:read])
+					}
 					err := writeAll(pt.output, buf[:read])
 					if err != nil {
-						// TODO: Error handling.
-						panic(err)
+						pt.fail(err)
 					}
 				}
 			}
@@ -246,6 +477,9 @@ func (pt *playingThread) worker() {
 	}
 }
 
+// play starts playing track at the given position. Tracks which fail
+// to play are reported and skipped, but no more than maxSkippedTracks
+// in a row.
 func (pt *playingThread) play(pos int, smooth bool) {
 	if pt.plist.Len() == 0 {
 		return
@@ -258,13 +492,72 @@ func (pt *playingThread) play(pos int, smooth bool) {
 	if pt.state == StatePlaying {
 		pt.stopBufAvailableChecker()
 	}
+	if !smooth {
+		// Track is changed by user, so the preloaded one
+		// is not the next one any more.
+		pt.dropNext()
+	}
+
+	tries := pt.plist.Len()
+	if tries > maxSkippedTracks {
+		tries = maxSkippedTracks
+	}
+	for {
+		err := pt.open(pos, smooth)
+		if err == nil {
+			break
+		}
+		pt.reportError(pt.plist.Get(pos), err)
+
+		// Skip failed track as if it has been played.
+		pt.pos = pos
+		next, ok := pt.next(pt.mode != ModeRepeatOne)
+		tries--
+		if !ok || tries == 0 {
+			if pt.output.IsOpen() {
+				pt.output.Close()
+			}
+			pt.pos = -1
+			pt.emitStatus()
+			return
+		}
+		pos = next
+	}
 
+	pt.pos = pos
+	pt.state = StatePlaying
 	track := pt.plist.Get(pos)
+	rate := int64(pt.decoder.SampleRate())
+	pt.pregap = int64(track.Pregap) * rate / vfs.FramesPerSecond
+	pt.postgap = int64(track.Postgap) * rate / vfs.FramesPerSecond
+	pt.outputs.SetTrack(track)
+	pt.updateScale()
+	pt.startBufAvailableChecker()
+	pt.emitStatus()
+}
+
+// open prepares decoder and output to play track at the given position.
+// Player is left in stopped state if error is returned.
+func (pt *playingThread) open(pos int, smooth bool) error {
+	track := pt.plist.Get(pos)
+	next := pt.takeNext(pos)
 	sameFile := false
+	contiguous := false
 
 	if pt.state == StatePlaying {
 		cur := pt.plist.Get(pt.pos)
 		sameFile = cur.Path.File() == track.Path.File()
+		// Decoder is already at the beginning of the next CUE
+		// track when the current one is finished.
+		contiguous = sameFile && smooth && cur.End != 0 &&
+			track.Start == cur.End
+	}
+
+	if pt.mode == ModeRandom && (pt.orderPos >= len(pt.order) ||
+		pt.order[pt.orderPos] != pos) {
+		// Track was selected explicitly, so start new random
+		// order from it.
+		pt.shuffle(pos)
 	}
 
 	// Do not reopen decoder if next track from the same physical file
@@ -275,46 +568,386 @@ func (pt *playingThread) play(pos int, smooth bool) {
 			pt.state = StateStopped
 		}
 
-		f := pt.fmts[track.Path.Ext()]
-		if f == nil {
-			// TODO: Skip this track and try next one.
-			panic("TODO:")
-		}
-		d, err := f.Decoder(track.Path.File())
-		if err != nil {
-			pt.state = StateStopped
-			// TODO: Skip this track and try next one.
-			panic("TODO:")
+		if next != nil {
+			pt.decoder = next
+		} else {
+			d, err := pt.openDecoder(track)
+			if err != nil {
+				return err
+			}
+			pt.decoder = d
 		}
-		pt.decoder = d
-	}
-	if track.Part {
-		pt.decoder.Seek(track.Start, false)
+	} else if !contiguous {
+		pt.decoder.SeekPosition(trackStart(track, pt.decoder))
 	}
 
+	dsr := pt.decoder.SampleRate()
+	dch := pt.decoder.Channels()
+	if smooth && pt.output.IsOpen() && (pt.output.SampleRate() != dsr ||
+		pt.output.Channels() != dch) {
+		// Output parameters can't be changed on the fly, so let
+		// the previous track finish and reopen the output.
+		pt.output.Drain()
+		pt.output.Close()
+	}
 	if !smooth && pt.output.IsOpen() {
 		pt.output.Close()
 	}
 	if !pt.output.IsOpen() {
 		err := pt.output.Open()
 		if err != nil {
-			// TODO: Some adequate error handling.
-			panic(err)
+			pt.decoder.Close()
+			pt.state = StateStopped
+			return err
 		}
 	}
 
 	osr := pt.output.SampleRate()
 	och := pt.output.Channels()
-	dsr := pt.decoder.SampleRate()
-	dch := pt.decoder.Channels()
 	if osr != dsr || och != dch {
 		pt.output.SetSampleRate(dsr)
 		pt.output.SetChannels(dch)
 	}
 
-	pt.pos = pos
-	pt.state = StatePlaying
-	pt.startBufAvailableChecker()
+	return nil
+}
+
+// read reads the current track data into buf. PREGAP silence precedes
+// the track data and POSTGAP silence follows it.
+func (pt *playingThread) read(buf []byte) int {
+	if pt.pregap > 0 {
+		return pt.silence(buf, &pt.pregap)
+	}
+	n := readTrack(pt.decoder, pt.plist.Get(pt.pos), buf)
+	if n == 0 && pt.postgap > 0 {
+		return pt.silence(buf, &pt.postgap)
+	}
+
+	return n
+}
+
+// silence fills buf with silence, but not more than left samples.
+// left is decreased by the number of samples written.
+func (pt *playingThread) silence(buf []byte, left *int64) int {
+	sampleSize := pt.decoder.Channels() * 2
+	n := len(buf) - len(buf)%sampleSize
+	if int64(n) > *left*int64(sampleSize) {
+		n = int(*left) * sampleSize
+	}
+	for i := range buf[:n] {
+		buf[i] = 0
+	}
+	*left -= int64(n / sampleSize)
+
+	return n
+}
+
+// openDecoder opens decoder for the track and seeks it to the track
+// beginning.
+func (pt *playingThread) openDecoder(track *vfs.Track) (format.Decoder, error) {
+	f := pt.fmts[track.Path.Ext()]
+	if f == nil {
+		return nil, errors.New("unsupported format")
+	}
+	d, err := f.Decoder(track.Path.File())
+	if err != nil {
+		return nil, err
+	}
+	if pt.outRate != 0 {
+		rd, err := resample(d, pt.outRate, pt.outChannels,
+			pt.newResampler)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d = rd
+	}
+	if track.Part {
+		d.SeekPosition(trackStart(track, d))
+	}
+
+	return d, nil
+}
+
+// preload opens decoder for the track to be played after the current
+// one when the current one is about to finish.
+func (pt *playingThread) preload() {
+	if pt.nextPos != -1 {
+		return
+	}
+	rem := pt.remaining()
+	if rem > preloadTime && rem > pt.crossfade {
+		return
+	}
+
+	orderPos := pt.orderPos
+	pos, ok := pt.next(true)
+	pt.orderPos = orderPos
+	if !ok {
+		return
+	}
+
+	pt.nextPos = pos
+	cur := pt.plist.Get(pt.pos)
+	track := pt.plist.Get(pos)
+	if cur.Path.File() == track.Path.File() {
+		return
+	}
+	d, err := pt.openDecoder(track)
+	if err != nil {
+		// Error is reported when the track is played.
+		return
+	}
+	pt.nextDecoder = d
+}
+
+// takeNext returns decoder preloaded for the track at pos or nil.
+func (pt *playingThread) takeNext(pos int) format.Decoder {
+	var d format.Decoder
+	if pos == pt.nextPos {
+		d = pt.nextDecoder
+		pt.nextDecoder = nil
+	}
+	pt.dropNext()
+
+	return d
+}
+
+// dropNext closes preloaded decoder and cancels crossfade.
+func (pt *playingThread) dropNext() {
+	if pt.nextDecoder != nil {
+		pt.nextDecoder.Close()
+		pt.nextDecoder = nil
+	}
+	pt.nextPos = -1
+	pt.fadePos = 0
+	pt.fadeLen = 0
+}
+
+// mixNext mixes the next track into the current track data in buf
+// if crossfade is in progress or it is time to start it.
+func (pt *playingThread) mixNext(buf []byte) {
+	if pt.fadeLen == 0 {
+		rem := pt.remaining()
+		if pt.nextDecoder == nil || rem <= 0 || rem > pt.crossfade {
+			return
+		}
+		d := pt.decoder
+		nd := pt.nextDecoder
+		if d.SampleRate() != nd.SampleRate() ||
+			d.Channels() != nd.Channels() {
+			return
+		}
+		// Two bytes per sample.
+		pt.fadeLen = int(pt.remainingSamples()) * d.Channels() * 2
+		pt.nextScale = gainScale(pt.plist.Get(pt.nextPos),
+			pt.replayGain, pt.mode == ModeRandom)
+	}
+
+	if len(pt.fadeBuf) < len(buf) {
+		pt.fadeBuf = make([]byte, len(buf))
+	}
+	next := pt.fadeBuf[:len(buf)]
+	n := 0
+	for n < len(next) {
+		r := readTrack(pt.nextDecoder, pt.plist.Get(pt.nextPos), next[n:])
+		if r == 0 {
+			break
+		}
+		n += r
+	}
+	for i := n; i < len(next); i++ {
+		next[i] = 0
+	}
+	applyGain(next[:n], pt.nextScale)
+	fadeMix(buf, next, pt.fadePos, pt.fadeLen)
+	pt.fadePos += len(buf)
+}
+
+// enableOutput enables or disables output. Output can't be used by
+// the buffer checker while it is opened or closed, so the checker
+// is stopped for this time.
+func (pt *playingThread) enableOutput(id int, enabled bool) error {
+	if pt.state == StatePlaying {
+		pt.stopBufAvailableChecker()
+		defer pt.startBufAvailableChecker()
+	}
+
+	return pt.outputs.Enable(id, enabled)
+}
+
+// updateScale updates samples scale in accordance with the current track
+// ReplayGain tags.
+func (pt *playingThread) updateScale() {
+	pt.scale = 1
+	if pt.state != StateStopped {
+		pt.scale = gainScale(pt.plist.Get(pt.pos), pt.replayGain,
+			pt.mode == ModeRandom)
+	}
+}
+
+// remaining returns number of seconds left till the end
+// of the current track.
+func (pt *playingThread) remaining() int {
+	return int(pt.remainingSamples() / int64(pt.decoder.SampleRate()))
+}
+
+// remainingSamples returns number of samples left till the end
+// of the current track including POSTGAP silence.
+func (pt *playingThread) remainingSamples() int64 {
+	t := pt.plist.Get(pt.pos)
+
+	return trackEndPos(t, pt.decoder) - pt.decoder.Position() + pt.postgap
+}
+
+// fail stops playback because of the current track error.
+func (pt *playingThread) fail(err error) {
+	pt.reportError(pt.plist.Get(pt.pos), err)
+	pt.stop()
+}
+
+func (pt *playingThread) reportError(track *vfs.Track, err error) {
+	e := &PlayError{Path: track.Path.String(), Err: err}
+	pt.lastError = e.Error()
+	if pt.errorHandler != nil {
+		pt.errorHandler(e)
+	}
+}
+
+// pause pauses (paused is true) or resumes playback.
+func (pt *playingThread) pause(paused bool) {
+	if pt.state == StatePlaying && paused {
+		pt.output.Pause()
+		pt.stopBufAvailableChecker()
+		pt.state = StatePaused
+		pt.emitStatus()
+	} else if pt.state == StatePaused && !paused {
+		pt.output.Pause()
+		pt.startBufAvailableChecker()
+		pt.state = StatePlaying
+		pt.emitStatus()
+	}
+}
+
+// trackEnd switches playback to the next track when the current one
+// is finished in accordance with the current mode.
+func (pt *playingThread) trackEnd() {
+	plist := pt.plist
+	pos := pt.pos
+
+	next, ok := pt.next(true)
+	if ok {
+		pt.play(next, true)
+	} else {
+		pt.stop()
+	}
+	if pt.mode == ModeConsume && pt.consumeHandler != nil {
+		go pt.consumeHandler(plist, pos)
+	}
+}
+
+// next returns position of the track to be played after the current one.
+// auto is true if the current track is finished and false if user
+// requested the next track. ok is false if there is nothing to play.
+func (pt *playingThread) next(auto bool) (pos int, ok bool) {
+	n := pt.plist.Len()
+
+	switch pt.mode {
+	case ModeRepeat:
+		return (pt.pos + 1) % n, true
+	case ModeRepeatOne:
+		if auto {
+			return pt.pos, true
+		}
+		return (pt.pos + 1) % n, true
+	case ModeRandom:
+		if pt.orderPos+1 < len(pt.order) {
+			pt.orderPos++
+			return pt.order[pt.orderPos], true
+		}
+		if auto {
+			return 0, false
+		}
+		pt.shuffle(-1)
+		return pt.order[0], true
+	default:
+		if pt.pos+1 < n {
+			return pt.pos + 1, true
+		}
+		if auto {
+			return 0, false
+		}
+		return 0, true
+	}
+}
+
+// prev returns position of the track to be played before the current one.
+func (pt *playingThread) prev() int {
+	if pt.mode == ModeRandom && pt.orderPos > 0 {
+		pt.orderPos--
+		return pt.order[pt.orderPos]
+	}
+
+	return pt.pos - 1
+}
+
+func (pt *playingThread) setMode(mode Mode) {
+	pt.dropNext()
+	pt.mode = mode
+	pt.order = nil
+	pt.orderPos = 0
+	if mode == ModeRandom && pt.plist != nil {
+		pt.shuffle(pt.pos)
+	}
+}
+
+// shuffle generates new random tracks order for the active playlist.
+// Track at the first position is placed at the beginning of the order
+// unless first is -1.
+func (pt *playingThread) shuffle(first int) {
+	pt.order = rand.Perm(pt.plist.Len())
+	pt.orderPos = 0
+	if first >= 0 {
+		for i, pos := range pt.order {
+			if pos == first {
+				pt.order[0], pt.order[i] = pt.order[i], pt.order[0]
+				break
+			}
+		}
+	}
+}
+
+// seek sets new playing position inside the current track. Position is
+// given in seconds from the track beginning if rel is false and
+// from the current position otherwise.
+func (pt *playingThread) seek(pos int, rel bool) {
+	if pt.state == StateStopped {
+		return
+	}
+
+	t := pt.plist.Get(pt.pos)
+	start := trackStart(t, pt.decoder)
+	end := trackEndPos(t, pt.decoder)
+	p := int64(pos) * int64(pt.decoder.SampleRate())
+	if rel {
+		p += pt.decoder.Position()
+	} else {
+		p += start
+	}
+	if p < start {
+		p = start
+	} else if p > end {
+		p = end
+	}
+
+	err := pt.decoder.SeekPosition(p)
+	if err != nil {
+		return
+	}
+	pt.pregap = 0
+	pt.dropNext()
+	// Drop already buffered data, so new position is heard immediately.
+	pt.output.Reset()
 	pt.emitStatus()
 }
 
@@ -325,6 +958,7 @@ func (pt *playingThread) stop() {
 		}
 		pt.output.Close()
 		pt.decoder.Close()
+		pt.dropNext()
 		pt.plist = nil
 		pt.pos = -1
 		pt.state = StateStopped
@@ -333,12 +967,14 @@ func (pt *playingThread) stop() {
 }
 
 func (pt *playingThread) setPlaylist(plist *Playlist) {
+	// Positions may be changed in the new playlist.
+	pt.dropNext()
 	// Try to find current track in new playlist.
 	if pt.state != StateStopped {
 		cur := pt.plist.Get(pt.pos)
 		pt.pos = -1
 		for i := 0; i < plist.Len(); i++ {
-			if plist.Get(i).Path.String() == cur.Path.String() {
+			if sameTrack(plist.Get(i), cur) {
 				pt.pos = i
 				break
 			}
@@ -348,11 +984,14 @@ func (pt *playingThread) setPlaylist(plist *Playlist) {
 		pt.stop()
 	}
 	pt.plist = plist
+	if pt.mode == ModeRandom {
+		pt.shuffle(pt.pos)
+	}
 }
 
 func (pt *playingThread) emitStatus() {
 	if pt.statusHandler != nil {
-		go pt.statusHandler(pt.status())
+		pt.statusHandler(pt.status())
 	}
 }
 
@@ -361,9 +1000,14 @@ func (pt *playingThread) status() *Status {
 	s.State = pt.state
 	s.Plist = pt.plist
 	s.PlistPos = pt.pos
+	s.Mode = pt.mode
+	s.Crossfade = pt.crossfade
+	s.ReplayGain = pt.replayGain
+	s.LastError = pt.lastError
 	if s.State != StateStopped {
 		t := pt.plist.Get(pt.pos)
-		s.Pos = pt.decoder.Time() - t.Start
+		s.Pos = int((pt.decoder.Position() - trackStart(t, pt.decoder)) /
+			int64(pt.decoder.SampleRate()))
 	}
 
 	return s
@@ -402,6 +1046,29 @@ func bufAvailChecker(pt *playingThread, output Output, ch chan struct{}) {
 	}
 }
 
+// readTrack decodes next piece of the track data. 0 is returned
+// when the track is finished.
+func readTrack(d format.Decoder, t *vfs.Track, buf []byte) int {
+	if t.Part && t.End != 0 {
+		// Stop exactly at the beginning of the next track.
+		sampleSize := int64(d.Channels() * 2)
+		left := (trackEndPos(t, d) - d.Position()) * sampleSize
+		if left <= 0 {
+			return 0
+		}
+		if left < int64(len(buf)) {
+			buf = buf[:left]
+		}
+	}
+	n, err := d.Read(buf)
+	if err != nil {
+		// Ignore errors and treat them as end of the file.
+		return 0
+	}
+
+	return n
+}
+
 func writeAll(w io.Writer, buf []byte) error {
 	for len(buf) > 0 {
 		n, err := w.Write(buf)
@@ -413,3 +1080,26 @@ func writeAll(w io.Writer, buf []byte) error {
 
 	return nil
 }
+
+// sameTrack returns true if both tracks refer to the same audio:
+// they have the same path or the same known fingerprint.
+func sameTrack(a *vfs.Track, b *vfs.Track) bool {
+	return a.Path.String() == b.Path.String() || a.ID != "" && a.ID == b.ID
+}
+
+// trackStart returns position of the track beginning in decoder
+// samples.
+func trackStart(t *vfs.Track, d format.Decoder) int64 {
+	return int64(t.Start) * int64(d.SampleRate()) / vfs.FramesPerSecond
+}
+
+// trackEndPos returns position of the track end in decoder samples.
+// End of the whole file track or the last CUE track is estimated
+// by the track length.
+func trackEndPos(t *vfs.Track, d format.Decoder) int64 {
+	if t.Part && t.End != 0 {
+		return int64(t.End) * int64(d.SampleRate()) / vfs.FramesPerSecond
+	}
+
+	return trackStart(t, d) + int64(t.Length)*int64(d.SampleRate())
+}
@@ -0,0 +1,357 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vchimishuk/chub/format"
+	"github.com/vchimishuk/chub/vfs"
+)
+
+// testFormat decodes files with testDecoder. Files with "bad" in
+// the name fail to open.
+type testFormat struct{}
+
+func (testFormat) Extensions() []string {
+	return []string{"test"}
+}
+
+func (testFormat) Metadata(path string) (format.Metadata, error) {
+	return nil, errors.New("not supported")
+}
+
+func (testFormat) Picture(path string) (*format.Picture, error) {
+	return nil, nil
+}
+
+func (testFormat) Decoder(path string) (format.Decoder, error) {
+	if strings.Contains(filepath.Base(path), "bad") {
+		return nil, errors.New("broken file")
+	}
+
+	// Ten seconds of mono audio.
+	return &testDecoder{data: make([]byte, 10*22050*2)}, nil
+}
+
+// testTracks creates files in the VFS root and returns ten seconds
+// tracks for them.
+func testTracks(t *testing.T, dir string, names ...string) []*vfs.Track {
+	err := vfs.SetRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tracks []*vfs.Track
+	for _, n := range names {
+		err := ioutil.WriteFile(filepath.Join(dir, n), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := vfs.NewPath("/" + n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracks = append(tracks, &vfs.Track{Path: p, Length: 10})
+	}
+
+	return tracks
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		mode     Mode
+		pos      int
+		order    []int
+		orderPos int
+		auto     bool
+		next     int
+		ok       bool
+		// Expected random order position after the call.
+		nextOrderPos int
+	}{
+		{ModeOff, 0, nil, 0, true, 1, true, 0},
+		{ModeOff, 2, nil, 0, true, 0, false, 0},
+		{ModeOff, 2, nil, 0, false, 0, true, 0},
+		{ModeRepeat, 1, nil, 0, true, 2, true, 0},
+		{ModeRepeat, 2, nil, 0, true, 0, true, 0},
+		{ModeRepeat, 2, nil, 0, false, 0, true, 0},
+		{ModeRepeatOne, 1, nil, 0, true, 1, true, 0},
+		{ModeRepeatOne, 1, nil, 0, false, 2, true, 0},
+		{ModeRepeatOne, 2, nil, 0, false, 0, true, 0},
+		{ModeRandom, 2, []int{2, 0, 1}, 0, true, 0, true, 1},
+		{ModeRandom, 0, []int{2, 0, 1}, 1, false, 1, true, 2},
+		{ModeRandom, 1, []int{2, 0, 1}, 2, true, 0, false, 2},
+		{ModeConsume, 0, nil, 0, true, 1, true, 0},
+		{ModeConsume, 2, nil, 0, true, 0, false, 0},
+	}
+
+	for i, test := range tests {
+		pt := &playingThread{
+			plist:    testPlaylist(3),
+			pos:      test.pos,
+			mode:     test.mode,
+			order:    test.order,
+			orderPos: test.orderPos,
+		}
+		next, ok := pt.next(test.auto)
+		if next != test.next || ok != test.ok ||
+			pt.orderPos != test.nextOrderPos {
+			t.Fatalf("test %d: %d, %t, %d expected but %d, %t, %d got",
+				i, test.next, test.ok, test.nextOrderPos,
+				next, ok, pt.orderPos)
+		}
+	}
+}
+
+func TestNextRandomReshuffle(t *testing.T) {
+	pt := &playingThread{
+		plist:    testPlaylist(3),
+		pos:      1,
+		mode:     ModeRandom,
+		order:    []int{2, 0, 1},
+		orderPos: 2,
+	}
+	// User asks for the next track after the last one in random
+	// order, so new order is started.
+	next, ok := pt.next(false)
+	if !ok || pt.orderPos != 0 || len(pt.order) != 3 ||
+		next != pt.order[0] {
+		t.Fatal()
+	}
+}
+
+func TestPrev(t *testing.T) {
+	tests := []struct {
+		mode     Mode
+		pos      int
+		order    []int
+		orderPos int
+		prev     int
+		// Expected random order position after the call.
+		prevOrderPos int
+	}{
+		{ModeOff, 1, nil, 0, 0, 0},
+		// play() wraps it to the last track.
+		{ModeOff, 0, nil, 0, -1, 0},
+		{ModeRepeatOne, 2, nil, 0, 1, 0},
+		{ModeRandom, 1, []int{2, 0, 1}, 2, 0, 1},
+		// Beginning of the random order.
+		{ModeRandom, 2, []int{2, 0, 1}, 0, 1, 0},
+	}
+
+	for i, test := range tests {
+		pt := &playingThread{
+			plist:    testPlaylist(3),
+			pos:      test.pos,
+			mode:     test.mode,
+			order:    test.order,
+			orderPos: test.orderPos,
+		}
+		prev := pt.prev()
+		if prev != test.prev || pt.orderPos != test.prevOrderPos {
+			t.Fatalf("test %d: %d, %d expected but %d, %d got",
+				i, test.prev, test.prevOrderPos, prev, pt.orderPos)
+		}
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	pt := &playingThread{plist: testPlaylist(5), pos: 3, nextPos: -1}
+
+	// Random order starts with the current track.
+	pt.setMode(ModeRandom)
+	if len(pt.order) != 5 || pt.order[0] != 3 || pt.orderPos != 0 {
+		t.Fatalf("unexpected order %v", pt.order)
+	}
+	sorted := make([]int, 5)
+	for _, pos := range pt.order {
+		sorted[pos]++
+	}
+	if !reflect.DeepEqual(sorted, []int{1, 1, 1, 1, 1}) {
+		t.Fatalf("order %v is not a permutation", pt.order)
+	}
+
+	pt.setMode(ModeRepeat)
+	if pt.mode != ModeRepeat || pt.order != nil || pt.orderPos != 0 {
+		t.Fatal()
+	}
+}
+
+func TestPlaySkipFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-player")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var names []string
+	for i := 0; i < maxSkippedTracks+2; i++ {
+		names = append(names, "bad"+strings.Repeat("x", i)+".test")
+	}
+	names = append(names, "good.test")
+	tracks := testTracks(t, dir, names...)
+
+	p := New([]format.Format{testFormat{}},
+		[]NamedOutput{{Name: "test", Output: &testOutput{},
+			Enabled: true}},
+		NewSoftMixer())
+	defer p.Close()
+	errs := make(chan *PlayError, len(tracks))
+	// Number of errors received before the good track status.
+	seen := 0
+	playing := make(chan int, 1)
+	p.AddEventHandler(func(e Event, args []interface{}) {
+		if e == EventError {
+			seen++
+			errs <- args[0].(*PlayError)
+		} else if e == EventStatus {
+			st := args[0].(*Status)
+			if st.State == StatePlaying && st.Plist != nil &&
+				st.Plist.Name() == "skip" && st.PlistPos == 4 {
+				select {
+				case playing <- seen:
+				default:
+				}
+			}
+		}
+	})
+	// waitErrors waits for n error events and checks that
+	// there are no more ones.
+	waitErrors := func(n int) []*PlayError {
+		var r []*PlayError
+		for len(r) < n {
+			select {
+			case e := <-errs:
+				r = append(r, e)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%d errors expected but %d got", n, len(r))
+			}
+		}
+		select {
+		case e := <-errs:
+			t.Fatalf("unexpected error %s", e)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		return r
+	}
+
+	// Failed tracks are skipped.
+	err = p.AddPlaylist(NewPlaylist("skip").Append(
+		tracks[maxSkippedTracks-2:]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.PlayPlaylist("skip", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := waitErrors(3)
+	for i, e := range failed {
+		if e.Path != tracks[maxSkippedTracks-1+i].Path.String() ||
+			e.Err.Error() != "broken file" {
+			t.Fatalf("unexpected error %s", e)
+		}
+	}
+	st := p.Status()
+	if st.State != StatePlaying || st.PlistPos != 4 ||
+		st.LastError != failed[2].Error() {
+		t.Fatalf("unexpected status %v", st)
+	}
+	// Errors are received before the status of the track
+	// which replaced the failed ones.
+	if n := <-playing; n != 3 {
+		t.Fatalf("status received after %d errors", n)
+	}
+
+	// Playback is stopped after too many failures in a row.
+	err = p.AddPlaylist(NewPlaylist("stop").Append(tracks...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.PlayPlaylist("stop", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed = waitErrors(maxSkippedTracks)
+	if failed[maxSkippedTracks-1].Path !=
+		tracks[maxSkippedTracks-1].Path.String() {
+		t.Fatalf("unexpected error %s", failed[maxSkippedTracks-1])
+	}
+	st = p.Status()
+	if st.State != StateStopped || st.PlistPos != -1 {
+		t.Fatalf("unexpected status %v", st)
+	}
+}
+
+func TestPreload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-player")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tracks := testTracks(t, dir, "a.test", "b.test")
+	tracks[0].Postgap = 3 * vfs.FramesPerSecond
+
+	out := &testOutput{}
+	pt := newPlayingThread([]format.Format{testFormat{}},
+		newMultiOutput([]NamedOutput{{Name: "test", Output: out,
+			Enabled: true}}),
+		NewSoftMixer())
+	pt.setPlaylist(NewPlaylist("test").Append(tracks...))
+	pt.play(0, false)
+	defer pt.stop()
+	if pt.state != StatePlaying {
+		t.Fatal()
+	}
+
+	// Four seconds of audio and three seconds of POSTGAP are left.
+	pt.seek(6, false)
+	if pt.remaining() != 7 {
+		t.Fatalf("7 seconds remaining expected but %d got",
+			pt.remaining())
+	}
+	pt.preload()
+	if pt.nextPos != -1 || pt.nextDecoder != nil {
+		t.Fatal("next track is preloaded too early")
+	}
+	pt.seek(8, false)
+	pt.preload()
+	if pt.nextPos != 1 || pt.nextDecoder == nil {
+		t.Fatal("next track is not preloaded")
+	}
+
+	// The next track starts with the preloaded decoder without
+	// reopening the output.
+	next := pt.nextDecoder
+	buf := make([]byte, 4096)
+	for pt.read(buf) > 0 {
+	}
+	pt.trackEnd()
+	if pt.state != StatePlaying || pt.pos != 1 || pt.decoder != next ||
+		out.opens != 1 || !out.open {
+		t.Fatal()
+	}
+}
@@ -65,3 +65,44 @@ func (pl *Playlist) Append(tracks ...*vfs.Track) *Playlist {
 
 	return &Playlist{name: pl.name, duration: d, tracks: t}
 }
+
+// Move returns new playlist with tracks from the from-to range
+// (both inclusive) moved to the pos position. pos is the position
+// in the playlist with the moved tracks removed.
+func (pl *Playlist) Move(from int, to int, pos int) *Playlist {
+	moved := pl.tracks[from : to+1]
+	rest := pl.Remove(from, to).tracks
+
+	t := make([]*vfs.Track, 0, len(pl.tracks))
+	t = append(t, rest[:pos]...)
+	t = append(t, moved...)
+	t = append(t, rest[pos:]...)
+
+	return &Playlist{name: pl.name, duration: pl.duration, tracks: t}
+}
+
+// Remove returns new playlist with tracks from the from-to range
+// (both inclusive) removed.
+func (pl *Playlist) Remove(from int, to int) *Playlist {
+	t := make([]*vfs.Track, 0, len(pl.tracks)-(to-from+1))
+	t = append(t, pl.tracks[:from]...)
+	t = append(t, pl.tracks[to+1:]...)
+
+	d := pl.duration
+	for _, t := range pl.tracks[from : to+1] {
+		d -= t.Length
+	}
+
+	return &Playlist{name: pl.name, duration: d, tracks: t}
+}
+
+// Replace returns new playlist with the track at the i position
+// replaced with t.
+func (pl *Playlist) Replace(i int, t *vfs.Track) *Playlist {
+	tracks := make([]*vfs.Track, len(pl.tracks))
+	copy(tracks, pl.tracks)
+	tracks[i] = t
+	d := pl.duration - pl.tracks[i].Length + t.Length
+
+	return &Playlist{name: pl.name, duration: d, tracks: tracks}
+}
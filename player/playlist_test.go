@@ -0,0 +1,79 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import (
+	"testing"
+
+	"github.com/vchimishuk/chub/vfs"
+)
+
+func testPlaylist(n int) *Playlist {
+	pl := NewPlaylist("test")
+	for i := 0; i < n; i++ {
+		pl = pl.Append(&vfs.Track{Number: i, Length: 10})
+	}
+
+	return pl
+}
+
+func assertTracks(t *testing.T, pl *Playlist, expected []int) {
+	if pl.Len() != len(expected) {
+		t.Fatalf("%d tracks expected but %d got", len(expected), pl.Len())
+	}
+	for i, n := range expected {
+		if pl.Get(i).Number != n {
+			t.Fatalf("track %d expected at %d but %d got",
+				n, i, pl.Get(i).Number)
+		}
+	}
+	if pl.Duration() != len(expected)*10 {
+		t.Fatalf("%d duration expected but %d got",
+			len(expected)*10, pl.Duration())
+	}
+}
+
+func TestPlaylistRemove(t *testing.T) {
+	pl := testPlaylist(5)
+
+	assertTracks(t, pl.Remove(1, 3), []int{0, 4})
+	assertTracks(t, pl.Remove(0, 0), []int{1, 2, 3, 4})
+	assertTracks(t, pl.Remove(0, 4), []int{})
+	// Original playlist must stay untouched.
+	assertTracks(t, pl, []int{0, 1, 2, 3, 4})
+}
+
+func TestPlaylistMove(t *testing.T) {
+	pl := testPlaylist(5)
+
+	assertTracks(t, pl.Move(0, 1, 3), []int{2, 3, 4, 0, 1})
+	assertTracks(t, pl.Move(3, 4, 0), []int{3, 4, 0, 1, 2})
+	assertTracks(t, pl.Move(2, 2, 2), []int{0, 1, 2, 3, 4})
+	assertTracks(t, pl, []int{0, 1, 2, 3, 4})
+}
+
+func TestPlaylistReplace(t *testing.T) {
+	pl := testPlaylist(3)
+
+	assertTracks(t, pl.Replace(1, &vfs.Track{Number: 7, Length: 10}),
+		[]int{0, 7, 2})
+	assertTracks(t, pl, []int{0, 1, 2})
+	if d := pl.Replace(0, &vfs.Track{Length: 25}).Duration(); d != 45 {
+		t.Fatalf("45 duration expected but %d got", d)
+	}
+}
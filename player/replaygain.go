@@ -0,0 +1,116 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/vchimishuk/chub/vfs"
+)
+
+// ReplayGainMode defines which ReplayGain adjustment is applied
+// to tracks.
+type ReplayGainMode int
+
+const (
+	// Do not apply ReplayGain.
+	ReplayGainOff ReplayGainMode = iota
+	// Apply track gain.
+	ReplayGainTrack
+	// Apply album gain.
+	ReplayGainAlbum
+	// Apply track gain in random mode and album gain otherwise.
+	ReplayGainAuto
+)
+
+var replayGainNames = map[ReplayGainMode]string{
+	ReplayGainOff:   "off",
+	ReplayGainTrack: "track",
+	ReplayGainAlbum: "album",
+	ReplayGainAuto:  "auto",
+}
+
+func (m ReplayGainMode) String() string {
+	return replayGainNames[m]
+}
+
+// ParseReplayGainMode returns ReplayGainMode by its string name.
+func ParseReplayGainMode(s string) (ReplayGainMode, error) {
+	for m, name := range replayGainNames {
+		if name == s {
+			return m, nil
+		}
+	}
+
+	return ReplayGainOff, errors.New("invalid replaygain mode")
+}
+
+// gainScale returns PCM samples scale factor for the track. If gain
+// of the requested type is missing (or has peak only) the other one
+// is used. Scale is limited by the peak value, so samples are not
+// clipped.
+func gainScale(t *vfs.Track, mode ReplayGainMode, random bool) float64 {
+	if mode == ReplayGainOff || t.Tag == nil {
+		return 1
+	}
+	if mode == ReplayGainAuto {
+		if random {
+			mode = ReplayGainTrack
+		} else {
+			mode = ReplayGainAlbum
+		}
+	}
+
+	g := t.Tag.TrackGain
+	if mode == ReplayGainAlbum && hasGain(t.Tag.AlbumGain) || !hasGain(g) {
+		g = t.Tag.AlbumGain
+	}
+	if !hasGain(g) {
+		return 1
+	}
+	scale := math.Pow(10, g.Gain/20)
+	if g.Peak > 0 && scale*g.Peak > 1 {
+		scale = 1 / g.Peak
+	}
+
+	return scale
+}
+
+// hasGain returns true if g has gain value.
+func hasGain(g *vfs.Gain) bool {
+	return g != nil && !g.PeakOnly
+}
+
+// applyGain scales signed 16 bit little endian PCM samples in buf.
+func applyGain(buf []byte, scale float64) {
+	if scale == 1 {
+		return
+	}
+
+	for i := 0; i+1 < len(buf); i += 2 {
+		s := float64(int16(binary.LittleEndian.Uint16(buf[i:]))) * scale
+		if s > math.MaxInt16 {
+			s = math.MaxInt16
+		} else if s < math.MinInt16 {
+			s = math.MinInt16
+		}
+		binary.LittleEndian.PutUint16(buf[i:], uint16(int16(s)))
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import (
+	"math"
+	"testing"
+
+	"github.com/vchimishuk/chub/vfs"
+)
+
+func TestGainScale(t *testing.T) {
+	track := &vfs.Track{Tag: &vfs.Tag{
+		TrackGain: &vfs.Gain{Gain: -6.0206},
+		AlbumGain: &vfs.Gain{Gain: 6.0206, Peak: 0.8},
+	}}
+	eq := func(a float64, b float64) bool {
+		return math.Abs(a-b) < 0.001
+	}
+
+	if gainScale(track, ReplayGainOff, false) != 1 {
+		t.Fatal()
+	}
+	if !eq(gainScale(track, ReplayGainTrack, false), 0.5) {
+		t.Fatal()
+	}
+	// Album gain is limited by the peak.
+	if !eq(gainScale(track, ReplayGainAlbum, false), 1.25) {
+		t.Fatal()
+	}
+	if !eq(gainScale(track, ReplayGainAuto, true), 0.5) ||
+		!eq(gainScale(track, ReplayGainAuto, false), 1.25) {
+		t.Fatal()
+	}
+	track.Tag.AlbumGain = nil
+	if !eq(gainScale(track, ReplayGainAlbum, false), 0.5) {
+		t.Fatal()
+	}
+	// Gain with peak only is the same as missing one.
+	track.Tag.AlbumGain = &vfs.Gain{Peak: 0.5, PeakOnly: true}
+	if !eq(gainScale(track, ReplayGainAlbum, false), 0.5) {
+		t.Fatal()
+	}
+	track.Tag.TrackGain = &vfs.Gain{Peak: 0.5, PeakOnly: true}
+	track.Tag.AlbumGain = &vfs.Gain{Gain: 6.0206}
+	if !eq(gainScale(track, ReplayGainTrack, false), 2) {
+		t.Fatal()
+	}
+	track.Tag.AlbumGain = nil
+	if gainScale(track, ReplayGainTrack, false) != 1 {
+		t.Fatal()
+	}
+}
+
+func TestApplyGain(t *testing.T) {
+	buf := []byte{0xe8, 0x03, 0x00, 0x80, 0xff, 0x7f}
+	applyGain(buf, 2)
+	exp := []byte{0xd0, 0x07, 0x00, 0x80, 0xff, 0x7f}
+	for i := range exp {
+		if buf[i] != exp[i] {
+			t.Fatalf("%v expected but %v got", exp, buf)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import (
+	"io"
+
+	"github.com/vchimishuk/chub/format"
+)
+
+// ResamplerFunc returns resampler to the given sample rate and number
+// of channels.
+type ResamplerFunc func(rate int, channels int) (format.Resampler, error)
+
+// resampledDecoder is a decoder which output is converted to the fixed
+// sample rate and number of channels.
+type resampledDecoder struct {
+	format.Decoder
+	resampler format.Resampler
+	rate      int
+	channels  int
+	// Source decoder read buffer.
+	buf []byte
+	// Converted data not read yet.
+	pending []byte
+	// Resampler kept samples are already flushed.
+	flushed bool
+}
+
+// resample returns decoder which output is converted to the given
+// format. d is returned as is if it already decodes to the format.
+func resample(d format.Decoder, rate int, channels int,
+	newResampler ResamplerFunc) (format.Decoder, error) {
+
+	if d.SampleRate() == rate && d.Channels() == channels {
+		return d, nil
+	}
+	r, err := newResampler(rate, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resampledDecoder{
+		Decoder:   d,
+		resampler: r,
+		rate:      rate,
+		channels:  channels,
+	}, nil
+}
+
+func (d *resampledDecoder) Read(buf []byte) (read int, err error) {
+	for len(d.pending) == 0 {
+		if len(d.buf) < len(buf) {
+			d.buf = make([]byte, len(buf))
+		}
+		n, err := d.Decoder.Read(d.buf[:len(buf)])
+		if n == 0 {
+			if d.flushed || (err != nil && err != io.EOF) {
+				return 0, err
+			}
+			// End of the stream, so samples kept by the
+			// resampler have to be played too.
+			d.flushed = true
+			d.pending, err = d.resampler.Flush()
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		d.pending, err = d.resampler.Convert(d.buf[:n],
+			d.Decoder.SampleRate(), d.Decoder.Channels())
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(buf, d.pending)
+	d.pending = d.pending[n:]
+
+	return n, nil
+}
+
+func (d *resampledDecoder) Seek(pos int, rel bool) error {
+	if rel {
+		pos += d.Time()
+		if pos < 0 {
+			pos = 0
+		}
+	}
+
+	return d.SeekPosition(int64(pos) * int64(d.rate))
+}
+
+func (d *resampledDecoder) Time() int {
+	return int(d.Position() / int64(d.rate))
+}
+
+// Position returns position in the output sample rate samples.
+func (d *resampledDecoder) Position() int64 {
+	p := d.Decoder.Position() * int64(d.rate) /
+		int64(d.Decoder.SampleRate())
+
+	return p - int64(len(d.pending)/(d.channels*2))
+}
+
+func (d *resampledDecoder) SeekPosition(pos int64) error {
+	d.pending = nil
+	d.flushed = false
+	d.resampler.Reset()
+
+	return d.Decoder.SeekPosition(pos * int64(d.Decoder.SampleRate()) /
+		int64(d.rate))
+}
+
+func (d *resampledDecoder) SampleRate() int {
+	return d.rate
+}
+
+func (d *resampledDecoder) Channels() int {
+	return d.channels
+}
+
+func (d *resampledDecoder) Close() {
+	d.resampler.Close()
+	d.Decoder.Close()
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package player
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vchimishuk/chub/format"
+	"github.com/vchimishuk/chub/vfs"
+)
+
+// testDecoder decodes mono data.
+type testDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *testDecoder) Read(buf []byte) (int, error) {
+	n := copy(buf, d.data[d.pos:])
+	d.pos += n
+	return n, nil
+}
+
+func (d *testDecoder) Seek(pos int, rel bool) error { return nil }
+func (d *testDecoder) Time() int                    { return 0 }
+func (d *testDecoder) Position() int64              { return int64(d.pos / 2) }
+func (d *testDecoder) SeekPosition(pos int64) error { d.pos = int(pos * 2); return nil }
+func (d *testDecoder) SampleRate() int              { return 22050 }
+func (d *testDecoder) Channels() int                { return 1 }
+func (d *testDecoder) Close()                       {}
+
+// testResampler upmixes mono to stereo and keeps the last sample till
+// the next call.
+type testResampler struct {
+	kept  []byte
+	reset bool
+}
+
+func (r *testResampler) Convert(buf []byte, rate int, channels int) ([]byte, error) {
+	in := append(r.kept, buf...)
+	r.kept = append([]byte(nil), in[len(in)-2:]...)
+	var out []byte
+	for i := 0; i+2 < len(in); i += 2 {
+		out = append(out, in[i], in[i+1], in[i], in[i+1])
+	}
+	return out, nil
+}
+
+func (r *testResampler) Flush() ([]byte, error) {
+	in := r.kept
+	r.kept = nil
+	var out []byte
+	for i := 0; i+1 < len(in); i += 2 {
+		out = append(out, in[i], in[i+1], in[i], in[i+1])
+	}
+	return out, nil
+}
+
+func (r *testResampler) Reset() { r.kept = nil; r.reset = true }
+func (r *testResampler) Close() {}
+
+func TestResample(t *testing.T) {
+	var tr *testResampler
+	newResampler := func(rate int, channels int) (format.Resampler, error) {
+		tr = &testResampler{}
+		return tr, nil
+	}
+
+	td := &testDecoder{}
+	d, err := resample(td, 22050, 1, newResampler)
+	if err != nil || d != td || tr != nil {
+		t.Fatal()
+	}
+
+	td.data = []byte{1, 0, 2, 0, 3, 0, 4, 0, 5, 0}
+	d, err = resample(td, 48000, 2, newResampler)
+	if err != nil || d.SampleRate() != 48000 || d.Channels() != 2 {
+		t.Fatal()
+	}
+	var out []byte
+	buf := make([]byte, 6)
+	for {
+		n, err := d.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		out = append(out, buf[:n]...)
+	}
+	exp := []byte{1, 0, 1, 0, 2, 0, 2, 0, 3, 0, 3, 0, 4, 0, 4, 0,
+		5, 0, 5, 0}
+	if !bytes.Equal(out, exp) {
+		t.Fatalf("%v expected but %v got", exp, out)
+	}
+
+	if n, err := d.Read(buf); n != 0 || err != nil {
+		t.Fatal(n, err)
+	}
+
+	d.Seek(0, false)
+	if !tr.reset {
+		t.Fatal()
+	}
+}
+
+func TestReadTrack(t *testing.T) {
+	d := &testDecoder{data: make([]byte, 22050*2*3)}
+	// Track lasts from 1s till 2s + 1/75s, that is 22344 samples.
+	track := &vfs.Track{Part: true, Start: 75, End: 151}
+	d.SeekPosition(trackStart(track, d))
+	buf := make([]byte, 4096)
+	n := 0
+	for {
+		r := readTrack(d, track, buf)
+		if r == 0 {
+			break
+		}
+		n += r
+	}
+	if n != 22344*2 || d.Position() != 44394 {
+		t.Fatalf("%d bytes read, position %d", n, d.Position())
+	}
+}
@@ -20,7 +20,9 @@ package serialize
 import (
 	"bytes"
 	"strconv"
+	"strings"
 
+	"github.com/vchimishuk/chub/library"
 	"github.com/vchimishuk/chub/player"
 	"github.com/vchimishuk/chub/vfs"
 )
@@ -66,6 +68,15 @@ func Track(track *vfs.Track) string {
 	return Map(trackToMap(track))
 }
 
+func Album(album *library.Album) string {
+	return Map(map[string]interface{}{
+		"artist": album.Artist,
+		"album":  album.Album,
+		"tracks": album.Tracks,
+		"length": album.Length,
+	})
+}
+
 func Playlist(plist *player.Playlist) string {
 	return Map(map[string]interface{}{
 		"name":     plist.Name(),
@@ -108,7 +119,57 @@ func trackToMap(track *vfs.Track) map[string]interface{} {
 		m["album"] = track.Tag.Album
 		m["title"] = track.Tag.Title
 		m["number"] = track.Tag.Number
+		tagToMap(track.Tag, m)
+	}
+	if p := track.Properties; p != nil {
+		m["codec"] = p.Codec
+		m["bitrate"] = p.Bitrate
+		m["bit-depth"] = p.BitDepth
+		m["sample-rate"] = p.SampleRate
+		m["channels"] = p.Channels
 	}
 
 	return m
 }
+
+// tagToMap adds optional tag fields to m. Only non-empty fields are
+// added. Extra tags are added with "x-" prefix.
+func tagToMap(tag *vfs.Tag, m map[string]interface{}) {
+	for k, v := range map[string]string{
+		"album-artist":                tag.AlbumArtist,
+		"date":                        tag.Date,
+		"genre":                       tag.Genre,
+		"composer":                    tag.Composer,
+		"isrc":                        tag.Isrc,
+		"musicbrainz-track-id":        tag.MusicBrainzTrackID,
+		"musicbrainz-album-id":        tag.MusicBrainzAlbumID,
+		"musicbrainz-artist-id":       tag.MusicBrainzArtistID,
+		"musicbrainz-album-artist-id": tag.MusicBrainzAlbumArtistID,
+	} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	for k, v := range map[string]int{
+		"track-total": tag.TrackTotal,
+		"disc-number": tag.DiscNumber,
+		"disc-total":  tag.DiscTotal,
+	} {
+		if v != 0 {
+			m[k] = v
+		}
+	}
+	for k, v := range tag.Extra {
+		m["x-"+extraKey(k)] = v
+	}
+}
+
+// extraKey converts arbitrary tag name to a valid response field name.
+func extraKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(k))
+}
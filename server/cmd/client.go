@@ -18,32 +18,51 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/base64"
 	"errors"
 	"net"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/vchimishuk/chub/cnet"
+	"github.com/vchimishuk/chub/cue"
+	"github.com/vchimishuk/chub/format"
+	"github.com/vchimishuk/chub/library"
+	"github.com/vchimishuk/chub/logger"
 	"github.com/vchimishuk/chub/player"
 	"github.com/vchimishuk/chub/serialize"
 	"github.com/vchimishuk/chub/vfs"
 )
 
+// Maximum number of picture bytes sent in a single albumart reply.
+const albumartChunk = 8192
+
 type Client struct {
-	conn     *CmdConn
-	srv      *cnet.Server
-	player   *player.Player
+	conn    *CmdConn
+	srv     *cnet.Server
+	player  *player.Player
+	library *library.Library
+	// The last requested album cover picture, so it is not read again
+	// for every chunk. Picture is reread when the first chunk
+	// is requested.
+	picPath  string
+	pic      *format.Picture
 	close    chan interface{}
 	closedMu sync.Mutex
 	closed   bool
 }
 
-func NewClient(conn net.Conn, srv *cnet.Server, p *player.Player) *Client {
+func NewClient(conn net.Conn, srv *cnet.Server, p *player.Player,
+	lib *library.Library) *Client {
+
 	return &Client{
-		conn:   newCmdConn(conn),
-		srv:    srv,
-		player: p,
-		close:  make(chan interface{}, 1),
+		conn:    newCmdConn(conn),
+		srv:     srv,
+		player:  p,
+		library: lib,
+		close:   make(chan interface{}, 1),
 	}
 }
 
@@ -69,15 +88,57 @@ func (c *Client) Serve() {
 			var lines []string
 
 			switch cmd.name {
+			case cmdAlbumart:
+				path := cmd.args[0].(string)
+				offset := cmd.args[1].(int)
+				lines, err = c.albumart(path, offset)
+			case cmdAlbums:
+				lines = c.albums()
+			case cmdArtists:
+				lines = c.artists()
+			case cmdCrossfade:
+				if len(cmd.args) > 0 {
+					err = c.player.SetCrossfade(cmd.args[0].(int))
+				}
+				if err == nil {
+					lines = c.crossfade()
+				}
+			case cmdCueSheet:
+				lines, err = c.cueSheet(cmd.args[0].(string))
+			case cmdFind:
+				field := cmd.args[0].(string)
+				value := cmd.args[1].(string)
+				lines, err = c.find(field, value)
 			case cmdKill:
 				quit = true
 				go c.srv.Close()
 			case cmdList:
 				lines, err = c.list(cmd.args[0].(string))
+			case cmdMode:
+				if len(cmd.args) > 0 {
+					lines, err = c.setMode(cmd.args[0].(string))
+				} else {
+					lines = c.mode()
+				}
+			case cmdReplayGain:
+				if len(cmd.args) > 0 {
+					lines, err = c.setReplayGain(cmd.args[0].(string))
+				} else {
+					lines = c.replayGain()
+				}
 			case cmdNext:
 				c.player.Next()
+			case cmdOutputs:
+				lines = c.outputs()
+			case cmdEnableOutput, cmdDisableOutput:
+				err = c.player.EnableOutput(cmd.args[0].(int),
+					cmd.name == cmdEnableOutput)
 			case cmdPause:
-				c.player.Pause()
+				if len(cmd.args) > 0 {
+					c.player.SetPaused(cmd.args[0].(bool))
+				} else {
+					c.player.Pause()
+				}
 			case cmdPing:
 				// Do nothing.
 			case cmdPlay:
@@ -90,10 +151,27 @@ func (c *Client) Serve() {
 				err = c.player.Clear(cmd.args[0].(string))
 			case cmdCreatePlaylist:
 				err = c.player.Create(cmd.args[0].(string))
-			case cmdPlaylistDelete:
+			case cmdDeletePlaylist, cmdPlaylistDelete:
 				err = c.player.Delete(cmd.args[0].(string))
+			case cmdPlaylistInfo:
+				lines, err = c.playlistInfo(cmd.args[0].(string))
 			case cmdPlaylistList:
 				lines, err = c.playlist(cmd.args[0].(string))
+			case cmdPlaylistMove:
+				name := cmd.args[0].(string)
+				from := cmd.args[1].(int)
+				to := cmd.args[2].(int)
+				pos := cmd.args[3].(int)
+				err = c.player.Move(name, from, to, pos)
+			case cmdPlaylistPlay:
+				name := cmd.args[0].(string)
+				pos := cmd.args[1].(int)
+				err = c.player.PlayPlaylist(name, pos)
+			case cmdPlaylistRemove:
+				name := cmd.args[0].(string)
+				from := cmd.args[1].(int)
+				to := cmd.args[2].(int)
+				err = c.player.Remove(name, from, to)
 			case cmdPlaylistRename:
 				oldName := cmd.args[0].(string)
 				newName := cmd.args[1].(string)
@@ -102,12 +180,37 @@ func (c *Client) Serve() {
 				lines = c.playlists()
 			case cmdPrev:
 				c.player.Prev()
+			case cmdRepeat:
+				if len(cmd.args) > 0 {
+					mode := player.ModeOff
+					if cmd.args[0].(bool) {
+						mode = player.ModeRepeat
+					}
+					c.player.SetMode(mode)
+				} else {
+					c.player.Repeat()
+				}
+			case cmdSearch:
+				lines = serializeTracks(c.library.Search(cmd.args[0].(string)))
+			case cmdSeek:
+				c.player.Seek(cmd.args[0].(int), cmd.args[1].(bool))
 			case cmdStatus:
 				lines = c.status()
 			case cmdStop:
 				c.player.Stop()
 			case cmdQuit:
 				quit = true
+			case cmdUpdate:
+				go c.update()
+			case cmdVolumn:
+				if len(cmd.args) > 0 {
+					vol := cmd.args[0].(int)
+					rel := cmd.args[1].(bool)
+					err = c.player.SetVolume(vol, rel)
+				}
+				if err == nil {
+					lines, err = c.volume()
+				}
 			default:
 				err := errors.New("unsupported command")
 				c.conn.WriteErrorResp(err)
@@ -174,6 +277,28 @@ func (c *Client) append(name string, path string) error {
 	return c.player.Append(name, p)
 }
 
+func (c *Client) albums() []string {
+	albums := c.library.Albums()
+	lines := make([]string, 0, len(albums))
+	for _, a := range albums {
+		lines = append(lines, serialize.Album(a))
+	}
+
+	return lines
+}
+
+func (c *Client) artists() []string {
+	artists := c.library.Artists()
+	lines := make([]string, 0, len(artists))
+	for _, a := range artists {
+		lines = append(lines, serialize.Map(map[string]interface{}{
+			"artist": a,
+		}))
+	}
+
+	return lines
+}
+
 func (c *Client) list(path string) ([]string, error) {
 	p, err := vfs.NewPath(path)
 	if err != nil {
@@ -195,6 +320,83 @@ func (c *Client) list(path string) ([]string, error) {
 	return lines, nil
 }
 
+// albumart returns picture size, MIME type and base64 encoded picture
+// data chunk starting at the given offset.
+func (c *Client) albumart(path string, offset int) ([]string, error) {
+	if c.pic == nil || c.picPath != path || offset == 0 {
+		p, err := vfs.NewPath(path)
+		if err != nil {
+			return nil, err
+		}
+		pic, err := p.Picture()
+		if err != nil {
+			return nil, err
+		}
+		c.picPath, c.pic = path, pic
+	}
+	size := len(c.pic.Data)
+	if offset < 0 || offset > size {
+		return nil, errors.New("invalid offset")
+	}
+	end := offset + albumartChunk
+	if end > size {
+		end = size
+	}
+
+	return []string{serialize.Map(map[string]interface{}{
+		"size":   size,
+		"mime":   c.pic.MIME,
+		"offset": offset,
+		"length": end - offset,
+		"data":   base64.StdEncoding.EncodeToString(c.pic.Data[offset:end]),
+	})}, nil
+}
+
+// cueSheet returns CUE sheet of the directory tracks, one sheet line
+// per reply line.
+func (c *Client) cueSheet(path string) ([]string, error) {
+	p, err := vfs.NewPath(path)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := p.CueSheet()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = cue.Write(&buf, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		lines = append(lines, serialize.Map(map[string]interface{}{
+			"line": l,
+		}))
+	}
+
+	return lines, nil
+}
+
+func (c *Client) find(field string, value string) ([]string, error) {
+	tracks, err := c.library.Find(field, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return serializeTracks(tracks), nil
+}
+
+func (c *Client) update() {
+	err := c.library.Update()
+	if err != nil {
+		logger.Error("library update failed: %s", err)
+	} else {
+		logger.Info("library updated: %d tracks", c.library.Len())
+	}
+}
+
 func (c *Client) playlist(name string) ([]string, error) {
 	plist, err := c.player.Playlist(name)
 	if err != nil {
@@ -209,6 +411,82 @@ func (c *Client) playlist(name string) ([]string, error) {
 	return lines, nil
 }
 
+func (c *Client) playlistInfo(name string) ([]string, error) {
+	plist, err := c.player.Playlist(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{serialize.Playlist(plist)}, nil
+}
+
+func (c *Client) setMode(name string) ([]string, error) {
+	mode, err := player.ParseMode(name)
+	if err != nil {
+		return nil, err
+	}
+	c.player.SetMode(mode)
+
+	return []string{serialize.Map(map[string]interface{}{
+		"mode": mode.String(),
+	})}, nil
+}
+
+func (c *Client) mode() []string {
+	return []string{serialize.Map(map[string]interface{}{
+		"mode": c.player.Status().Mode.String(),
+	})}
+}
+
+func (c *Client) setReplayGain(name string) ([]string, error) {
+	mode, err := player.ParseReplayGainMode(name)
+	if err != nil {
+		return nil, err
+	}
+	c.player.SetReplayGain(mode)
+
+	return []string{serialize.Map(map[string]interface{}{
+		"replaygain": mode.String(),
+	})}, nil
+}
+
+func (c *Client) replayGain() []string {
+	return []string{serialize.Map(map[string]interface{}{
+		"replaygain": c.player.Status().ReplayGain.String(),
+	})}
+}
+
+func (c *Client) outputs() []string {
+	outs := c.player.Outputs()
+	lines := make([]string, 0, len(outs))
+	for _, o := range outs {
+		lines = append(lines, serialize.Map(map[string]interface{}{
+			"id":      o.ID,
+			"name":    o.Name,
+			"enabled": o.Enabled,
+		}))
+	}
+
+	return lines
+}
+
+func (c *Client) crossfade() []string {
+	return []string{serialize.Map(map[string]interface{}{
+		"crossfade": c.player.Status().Crossfade,
+	})}
+}
+
+func (c *Client) volume() ([]string, error) {
+	vol, err := c.player.Volume()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{serialize.Map(map[string]interface{}{
+		"volume": vol,
+	})}, nil
+}
+
 func (c *Client) playlists() []string {
 	plists := c.player.Playlists()
 	lines := make([]string, 0, len(plists))
@@ -226,9 +504,18 @@ func (c *Client) status() []string {
 	//	s := c.player.State()
 
 	if st.State == player.StateStopped {
-		return []string{serialize.Map(map[string]interface{}{
-			"state": "stopped",
-		})}
+		m := map[string]interface{}{
+			"state":      "stopped",
+			"mode":       st.Mode.String(),
+			"volume":     st.Volume,
+			"crossfade":  st.Crossfade,
+			"replaygain": st.ReplayGain.String(),
+		}
+		if st.LastError != "" {
+			m["last-error"] = st.LastError
+		}
+
+		return []string{serialize.Map(m)}
 	} else {
 		var s string
 		if st.State == player.StatePlaying {
@@ -240,8 +527,12 @@ func (c *Client) status() []string {
 		}
 		track := st.Plist.Get(st.PlistPos)
 
-		return []string{serialize.Map(map[string]interface{}{
+		m := map[string]interface{}{
 			"state":             s,
+			"mode":              st.Mode.String(),
+			"volume":            st.Volume,
+			"crossfade":         st.Crossfade,
+			"replaygain":        st.ReplayGain.String(),
 			"playlist-position": st.PlistPos,
 			"track-position":    st.Pos,
 			"playlist-name":     st.Plist.Name(),
@@ -253,6 +544,27 @@ func (c *Client) status() []string {
 			"track-title":       track.Tag.Title,
 			"track-number":      track.Tag.Number,
 			"track-length":      track.Length,
-		})}
+		}
+		if p := track.Properties; p != nil {
+			m["track-codec"] = p.Codec
+			m["track-bitrate"] = p.Bitrate
+			m["track-bit-depth"] = p.BitDepth
+			m["track-sample-rate"] = p.SampleRate
+			m["track-channels"] = p.Channels
+		}
+		if st.LastError != "" {
+			m["last-error"] = st.LastError
+		}
+
+		return []string{serialize.Map(m)}
+	}
+}
+
+func serializeTracks(tracks []*vfs.Track) []string {
+	lines := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		lines = append(lines, serialize.Track(t))
 	}
+
+	return lines
 }
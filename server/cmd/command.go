@@ -20,25 +20,40 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 const (
-	// TODO: Replace BACKWARD & FORWARD with SEEK command.
-
-	// Seek playing track position backward.
-	cmdBackward = "backward"
+	// Show a chunk of the track's or directory's album cover picture.
+	cmdAlbumart = "albumart"
+	// Show library albums list.
+	cmdAlbums = "albums"
+	// Show library artists list.
+	cmdArtists = "artists"
+	// Show or set crossfade duration.
+	cmdCrossfade = "crossfade"
 	// Create new playlist.
 	cmdCreatePlaylist = "create-playlist"
+	// Show CUE sheet of the directory tracks.
+	cmdCueSheet = "cuesheet"
 	// Delete existing playlist.
 	cmdDeletePlaylist = "delete-playlist"
-	// Seek playing track position forward.
-	cmdForward = "forward"
+	// Disable output by its ID.
+	cmdDisableOutput = "disableoutput"
+	// Enable output by its ID.
+	cmdEnableOutput = "enableoutput"
+	// Find library tracks by tag value.
+	cmdFind = "find"
 	// Stop the server.
 	cmdKill = "kill"
 	// Show directory contents.
 	cmdList = "list"
+	// Show or set playback mode (off, repeat, repeat-one, random, consume).
+	cmdMode = "mode"
 	// Play next track in the current playing playlist.
 	cmdNext = "next"
+	// Show outputs list.
+	cmdOutputs = "outputs"
 	// Toggle paused state.
 	cmdPause = "pause"
 	// Do nothing, just returns "OK" response.
@@ -49,14 +64,18 @@ const (
 	cmdPlaylistAppend = "playlist-append"
 	// Remove all items from playlist.
 	cmdPlaylistClear = "playlist-clear"
-	// Delete items from playlist.
+	// Delete playlist. Obsolete alias for delete-playlist.
 	cmdPlaylistDelete = "playlist-delete"
+	// Move items (single one or range) inside playlist.
+	cmdPlaylistMove = "playlist-move"
+	// Show playlist information: name, duration and length.
+	cmdPlaylistInfo = "playlist-info"
 	// Show playlist tracks.
 	cmdPlaylistList = "playlist-list"
 	// Start playing given playlist.
 	cmdPlaylistPlay = "playlist-play"
-	// Move items inside playlist.
-	cmdPlaylistRemove = "playlist-move"
+	// Remove items (single one or range) from playlist.
+	cmdPlaylistRemove = "playlist-remove"
 	// Rename playlist.
 	cmdPlaylistRename = "rename-playlist"
 	// Show existing playlists list.
@@ -65,12 +84,20 @@ const (
 	cmdPrev = "prev"
 	// Disconnect from server.
 	cmdQuit = "quit"
-	// Set/toggle repeat mode.
+	// Set/toggle repeat mode. Shortcut for repeat and off modes.
 	cmdRepeat = "repeat"
+	// Show or set ReplayGain mode (off, track, album, auto).
+	cmdReplayGain = "replaygain"
+	// Search library tracks.
+	cmdSearch = "search"
+	// Set absolute or relative playing track position.
+	cmdSeek = "seek"
 	// Returns player's current state (playback status, volume, etc.).
 	cmdStatus = "status"
 	// Stop playing if active.
 	cmdStop = "stop"
+	// Rescan music library.
+	cmdUpdate = "update"
 	// Change volume level.
 	cmdVolumn = "volume"
 )
@@ -94,14 +121,16 @@ func parseCommand(str string) (*command, error) {
 	}
 
 	switch name {
-	case cmdCreatePlaylist, cmdList, cmdPlay, cmdPlaylistClear:
+	case cmdCreatePlaylist, cmdDeletePlaylist, cmdList, cmdPlay:
+		fallthrough
+	case cmdPlaylistClear, cmdPlaylistDelete, cmdPlaylistInfo:
 		fallthrough
-	case cmdPlaylistDelete, cmdPlaylistList:
+	case cmdCueSheet, cmdPlaylistList:
 		// One string argument command.
 		path, e := s.NextString()
 		args = []interface{}{path}
 		err = e
-	case cmdPlaylistAppend, cmdPlaylistRename:
+	case cmdFind, cmdPlaylistAppend, cmdPlaylistRename:
 		// Two string arguments command.
 		path := ""
 		name, e := s.NextString()
@@ -110,9 +139,98 @@ func parseCommand(str string) (*command, error) {
 		}
 		args = []interface{}{name, path}
 		err = e
-	case cmdKill, cmdNext, cmdPause, cmdPing, cmdPlaylists:
+	case cmdAlbumart:
+		// String and optional integer arguments command.
+		offset := 0
+		path, e := s.NextString()
+		if e == nil && s.HasNext() {
+			offset, e = s.NextInt()
+		}
+		args = []interface{}{path, offset}
+		err = e
+	case cmdPlaylistPlay:
+		// String and integer arguments command.
+		pos := 0
+		name, e := s.NextString()
+		if e == nil {
+			pos, e = s.NextInt()
+		}
+		args = []interface{}{name, pos}
+		err = e
+	case cmdPlaylistRemove:
+		// String and range arguments command.
+		from, to := 0, 0
+		name, e := s.NextString()
+		if e == nil {
+			from, to, e = s.NextRange()
+		}
+		args = []interface{}{name, from, to}
+		err = e
+	case cmdPlaylistMove:
+		// String, range and integer arguments command.
+		from, to, pos := 0, 0, 0
+		name, e := s.NextString()
+		if e == nil {
+			from, to, e = s.NextRange()
+		}
+		if e == nil {
+			pos, e = s.NextInt()
+		}
+		args = []interface{}{name, from, to, pos}
+		err = e
+	case cmdSearch:
+		// One or more string arguments command.
+		var words []string
+		for err == nil && s.HasNext() {
+			var w string
+			w, err = s.NextString()
+			words = append(words, w)
+		}
+		if len(words) == 0 {
+			err = errors.New("missing argument")
+		}
+		args = []interface{}{strings.Join(words, " ")}
+	case cmdEnableOutput, cmdDisableOutput:
+		// One integer argument command.
+		n, e := s.NextInt()
+		args = []interface{}{n}
+		err = e
+	case cmdSeek:
+		// Absolute or relative integer argument command.
+		n, rel, e := s.NextRelInt()
+		args = []interface{}{n, rel}
+		err = e
+	case cmdPause, cmdRepeat:
+		// Optional on/off argument command.
+		if s.HasNext() {
+			b, e := s.NextBool()
+			args = []interface{}{b}
+			err = e
+		}
+	case cmdMode, cmdReplayGain:
+		// Optional string argument command.
+		if s.HasNext() {
+			m, e := s.NextString()
+			args = []interface{}{m}
+			err = e
+		}
+	case cmdCrossfade:
+		// Optional integer argument command.
+		if s.HasNext() {
+			n, e := s.NextInt()
+			args = []interface{}{n}
+			err = e
+		}
+	case cmdVolumn:
+		// Optional absolute or relative integer argument command.
+		if s.HasNext() {
+			n, rel, e := s.NextRelInt()
+			args = []interface{}{n, rel}
+			err = e
+		}
+	case cmdAlbums, cmdArtists, cmdKill, cmdNext, cmdPing, cmdPlaylists:
 		// Argumentless command.
-	case cmdPrev, cmdQuit, cmdStatus, cmdStop:
+	case cmdPrev, cmdQuit, cmdStatus, cmdStop, cmdUpdate:
 		// Argumentless command.
 	default:
 		return nil, errors.New("unsupported command")
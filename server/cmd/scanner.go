@@ -110,6 +110,68 @@ func (s *scanner) NextInt() (int, error) {
 	return n, nil
 }
 
+// NextRelInt reads integer which can be prefixed with + or - sign.
+// Signed value is treated as a relative one (rel is true), unsigned
+// as an absolute one.
+func (s *scanner) NextRelInt() (n int, rel bool, err error) {
+	s.eatSpaces()
+
+	r, _, err := s.reader.ReadRune()
+	if err != nil {
+		return 0, false, errors.New("invalid integer format")
+	}
+	s.reader.UnreadRune()
+	rel = r == '-' || r == '+'
+
+	n, err = s.NextInt()
+	if err != nil {
+		return 0, false, err
+	}
+
+	return n, rel, nil
+}
+
+// NextRange reads range of non-negative integers in N-M format.
+// Single N integer is treated as N-N range.
+func (s *scanner) NextRange() (from int, to int, err error) {
+	str, err := s.NextString()
+	if err != nil {
+		return 0, 0, errors.New("invalid range format")
+	}
+
+	parts := strings.SplitN(str, "-", 2)
+	from, err = strconv.Atoi(parts[0])
+	if err != nil || from < 0 {
+		return 0, 0, errors.New("invalid range format")
+	}
+	to = from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(parts[1])
+		if err != nil || to < from {
+			return 0, 0, errors.New("invalid range format")
+		}
+	}
+
+	return from, to, nil
+}
+
+// NextBool reads on/off switch value.
+func (s *scanner) NextBool() (bool, error) {
+	str, err := s.NextString()
+	if err != nil {
+		return false, errors.New("invalid on/off format")
+	}
+
+	switch strings.ToLower(str) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	default:
+		return false, errors.New("invalid on/off format")
+	}
+}
+
 func (s *scanner) eatSpaces() {
 	for {
 		r, _, err := s.reader.ReadRune()
@@ -113,3 +113,57 @@ func TestCommand(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestRelInt(t *testing.T) {
+	s := newScanner("+5 -10 42")
+
+	n, rel, err := s.NextRelInt()
+	if err != nil || n != 5 || !rel {
+		t.Fatal()
+	}
+	n, rel, err = s.NextRelInt()
+	if err != nil || n != -10 || !rel {
+		t.Fatal()
+	}
+	n, rel, err = s.NextRelInt()
+	if err != nil || n != 42 || rel {
+		t.Fatal()
+	}
+	if s.HasNext() {
+		t.Fatal()
+	}
+}
+
+func TestRange(t *testing.T) {
+	s := newScanner("3 4-7 7-4")
+
+	from, to, err := s.NextRange()
+	if err != nil || from != 3 || to != 3 {
+		t.Fatal()
+	}
+	from, to, err = s.NextRange()
+	if err != nil || from != 4 || to != 7 {
+		t.Fatal()
+	}
+	_, _, err = s.NextRange()
+	if err == nil {
+		t.Fatal()
+	}
+}
+
+func TestBool(t *testing.T) {
+	s := newScanner("on OFF yes")
+
+	b, err := s.NextBool()
+	if err != nil || !b {
+		t.Fatal()
+	}
+	b, err = s.NextBool()
+	if err != nil || b {
+		t.Fatal()
+	}
+	_, err = s.NextBool()
+	if err == nil {
+		t.Fatal()
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"net"
 
 	"github.com/vchimishuk/chub/cnet"
+	"github.com/vchimishuk/chub/library"
 	"github.com/vchimishuk/chub/player"
 )
 
@@ -28,9 +29,9 @@ type Server struct {
 	srv *cnet.Server
 }
 
-func NewServer(p *player.Player) *Server {
+func NewServer(p *player.Player, lib *library.Library) *Server {
 	srv := cnet.NewServer(func(conn net.Conn, s *cnet.Server) cnet.Client {
-		return NewClient(conn, s, p)
+		return NewClient(conn, s, p, lib)
 	})
 
 	return &Server{srv: srv}
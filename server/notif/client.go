@@ -18,37 +18,152 @@
 package notif
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"strings"
 	"sync"
 
 	"github.com/vchimishuk/chub/cnet"
+	"github.com/vchimishuk/chub/library"
+	"github.com/vchimishuk/chub/logger"
 	"github.com/vchimishuk/chub/player"
 	"github.com/vchimishuk/chub/serialize"
+	"github.com/vchimishuk/chub/vfs"
 )
 
+const (
+	// Subscribe to the given events list or to all events
+	// if the list is empty.
+	cmdSubscribe = "subscribe"
+	// Unsubscribe from the given events list or from all events
+	// if the list is empty.
+	cmdUnsubscribe = "unsubscribe"
+	// Disconnect from server.
+	cmdQuit = "quit"
+)
+
+// Periodic playing position event, which is sent only to subscribed
+// clients.
+const eventPosition player.Event = "position"
+
+// Library change event. Argument is *library.Change.
+const eventLibrary player.Event = "library"
+
+// Events every new client is subscribed to.
+var defaultEvents = []player.Event{
+	player.EventStatus,
+	player.EventVolume,
+	player.EventPlaylist,
+	player.EventPlaylists,
+	player.EventError,
+	eventLibrary,
+}
+
+// All events client can subscribe to.
+var allEvents = append(defaultEvents[:len(defaultEvents):len(defaultEvents)],
+	eventPosition)
+
+// Maximum number of messages waiting to be sent to the client.
+// Client which doesn't read them fast enough is disconnected, so it
+// doesn't slow down event delivery to others.
+const queueSize = 256
+
 type responseLine map[string]interface{}
 
+// message is a header line followed by lines and an empty line.
+type message struct {
+	header string
+	lines  []string
+}
+
 type Client struct {
-	conn     *cnet.TextConn
+	conn   *cnet.TextConn
+	player *player.Player
+	// Messages to be sent by writer goroutine.
+	queue chan *message
+	// Mutex guards subs field.
+	subsMu   sync.Mutex
+	subs     map[player.Event]bool
 	closedMu sync.Mutex
 	closed   bool
+	// Closed when client is closed to stop writer goroutine.
+	done chan struct{}
 }
 
-func NewClient(conn net.Conn) *Client {
-	return &Client{conn: cnet.NewTextConn(conn)}
+func NewClient(conn net.Conn, p *player.Player) *Client {
+	subs := make(map[player.Event]bool)
+	for _, e := range defaultEvents {
+		subs[e] = true
+	}
+
+	c := &Client{
+		conn:   cnet.NewTextConn(conn),
+		player: p,
+		queue:  make(chan *message, queueSize),
+		subs:   subs,
+		done:   make(chan struct{}),
+	}
+	go c.writer()
+
+	return c
 }
 
 func (c *Client) Close() error {
 	c.closedMu.Lock()
-	err := c.conn.Close()
+	defer c.closedMu.Unlock()
+
+	if c.closed {
+		return nil
+	}
 	c.closed = true
-	c.closedMu.Unlock()
+	close(c.done)
 
-	return err
+	return c.conn.Close()
 }
 
+// Serve reads and handles client's subscription commands.
 func (c *Client) Serve() {
+	for {
+		line, err := c.conn.ReadLine()
+		if err != nil {
+			break
+		}
 
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			c.writeResp(errors.New("invalid command"))
+			continue
+		}
+		events, err := parseEvents(fields[1:])
+		if err != nil {
+			c.writeResp(err)
+			continue
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case cmdSubscribe:
+			if len(events) == 0 {
+				events = allEvents
+			}
+			c.subscribe(events, true)
+			c.writeResp(nil)
+			c.snapshot(events)
+		case cmdUnsubscribe:
+			if len(events) == 0 {
+				events = allEvents
+			}
+			c.subscribe(events, false)
+			c.writeResp(nil)
+		case cmdQuit:
+			c.Close()
+			return
+		default:
+			c.writeResp(errors.New("unsupported command"))
+		}
+	}
+
+	c.Close()
 }
 
 func (c *Client) IsClosed() bool {
@@ -58,67 +173,265 @@ func (c *Client) IsClosed() bool {
 	return c.closed
 }
 
+// Subscribed returns true if client is subscribed to the event.
+func (c *Client) Subscribed(e player.Event) bool {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	return c.subs[e]
+}
+
+// Notify sends the event to the client if it is subscribed to it.
 func (c *Client) Notify(e player.Event, args []interface{}) error {
-	_, err := c.conn.WriteLine(string(e))
-	if err != nil {
-		c.Close()
-		return err
+	if !c.Subscribed(e) {
+		return nil
 	}
 
-	var lines []responseLine
+	var lines []string
 
 	switch e {
 	case player.EventStatus:
-		lines = c.status(args[0].(*player.Status))
-	// case player.PlaylistEvent:
-	// 	name := msg.Args[0].(string)
-	// 	tracks := msg.Args[1].([]*vfs.Track)
-	// 	c.playlist(name, tracks)
-	// case player.PlaylistsEvent:
-	// 	plists := msg.Args[0].([]*player.Playlist)
-	// 	c.playlists(plists)
+		lines = serializeLines(c.status(args[0].(*player.Status)))
+	case player.EventVolume:
+		lines = serializeLines([]responseLine{{"volume": args[0].(int)}})
+	case player.EventPlaylist:
+		lines = c.playlist(args[0].(*player.PlaylistChange))
+	case player.EventPlaylists:
+		lines = c.playlists(args[0].(*player.PlaylistsChange))
+	case player.EventError:
+		pe := args[0].(*player.PlayError)
+		lines = serializeLines([]responseLine{
+			{"track-path": pe.Path},
+			{"error": pe.Err.Error()},
+		})
+	case eventPosition:
+		lines = serializeLines(c.position(args[0].(*player.Status)))
+	case eventLibrary:
+		lines = c.library(args[0].(*library.Change))
 	default:
-		panic("unsupported event")
+		return fmt.Errorf("unsupported event %s", e)
 	}
 
-	for _, l := range lines {
-		_, err := c.conn.WriteLine(serialize.Map(l))
-		if err != nil {
-			c.Close()
-			return err
+	return c.write(string(e), lines)
+}
+
+func (c *Client) subscribe(events []player.Event, sub bool) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	for _, e := range events {
+		if sub {
+			c.subs[e] = true
+		} else {
+			delete(c.subs, e)
 		}
+	}
+}
 
+// snapshot sends current state for every given event, so client
+// doesn't need to request it separately after subscription.
+// Playlists are sent as a sequence of "created" and "appended" events.
+func (c *Client) snapshot(events []player.Event) {
+	for _, e := range events {
+		switch e {
+		case player.EventStatus:
+			c.Notify(e, []interface{}{c.player.Status()})
+		case player.EventVolume:
+			if vol, err := c.player.Volume(); err == nil {
+				c.Notify(e, []interface{}{vol})
+			}
+		case player.EventPlaylists:
+			for _, pl := range c.player.Playlists() {
+				c.Notify(e, []interface{}{&player.PlaylistsChange{
+					Action: player.ActionCreated,
+					Name:   pl.Name(),
+				}})
+			}
+		case player.EventPlaylist:
+			for _, pl := range c.player.Playlists() {
+				tracks := make([]*vfs.Track, 0, pl.Len())
+				for i := 0; i < pl.Len(); i++ {
+					tracks = append(tracks, pl.Get(i))
+				}
+				c.Notify(e, []interface{}{&player.PlaylistChange{
+					Action: player.ActionAppended,
+					Name:   pl.Name(),
+					Tracks: tracks,
+				}})
+			}
+		case eventPosition:
+			st := c.player.Status()
+			if st.State != player.StateStopped {
+				c.Notify(e, []interface{}{st})
+			}
+		}
 	}
-	_, err = c.conn.WriteLine("")
+}
+
+// writeResp writes OK response if err is nil and ERR one otherwise.
+func (c *Client) writeResp(err error) error {
 	if err != nil {
+		return c.write(fmt.Sprintf("ERR %s", err), nil)
+	}
+
+	return c.write("OK", nil)
+}
+
+// write queues header line followed by the given lines and empty
+// line to be sent. Client is disconnected if its queue is full.
+func (c *Client) write(header string, lines []string) error {
+	if c.IsClosed() {
+		return errors.New("client is closed")
+	}
+	select {
+	case c.queue <- &message{header: header, lines: lines}:
+		return nil
+	default:
+		logger.Warning("notification client is too slow, disconnecting")
 		c.Close()
+		return errors.New("client queue is full")
+	}
+}
+
+// writer sends queued messages till the client is closed.
+func (c *Client) writer() {
+	for {
+		select {
+		case m := <-c.queue:
+			err := c.send(m)
+			if err != nil {
+				c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) send(m *message) error {
+	_, err := c.conn.WriteLine(m.header)
+	if err != nil {
 		return err
 	}
-	err = c.conn.Flush()
+	for _, l := range m.lines {
+		_, err := c.conn.WriteLine(l)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = c.conn.WriteLine("")
 	if err != nil {
-		c.Close()
 		return err
 	}
 
-	return nil
+	return c.conn.Flush()
+}
+
+func parseEvents(names []string) ([]player.Event, error) {
+	events := make([]player.Event, 0, len(names))
+	for _, n := range names {
+		e := player.Event(strings.ToLower(n))
+		valid := false
+		for _, ee := range allEvents {
+			if e == ee {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unsupported event %s", n)
+		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+func (c *Client) position(st *player.Status) []responseLine {
+	return []responseLine{
+		{"playlist-position": st.PlistPos},
+		{"track-position": st.Pos},
+	}
+}
+
+// playlist returns playlist change description line followed by
+// appended or replacing tracks (if any) lines.
+func (c *Client) playlist(ch *player.PlaylistChange) []string {
+	l := responseLine{"action": ch.Action, "name": ch.Name}
+	switch ch.Action {
+	case player.ActionAppended:
+		l["position"] = ch.Pos
+	case player.ActionRemoved:
+		l["from"] = ch.From
+		l["to"] = ch.To
+	case player.ActionMoved:
+		l["from"] = ch.From
+		l["to"] = ch.To
+		l["position"] = ch.Pos
+	case player.ActionReplaced:
+		l["from"] = ch.From
+		l["to"] = ch.To
+	}
+
+	lines := make([]string, 0, len(ch.Tracks)+1)
+	lines = append(lines, serialize.Map(l))
+	for _, t := range ch.Tracks {
+		lines = append(lines, serialize.Track(t))
+	}
+
+	return lines
 }
 
-// func (c *Client) playlists(plists []*player.Playlist) {
-// 	for _, pl := range plists {
-// 		c.conn.WriteLine(serialize.Playlist(pl))
-// 	}
-// }
+// library returns numbers of added and removed tracks line followed
+// by removed tracks paths and added tracks lines.
+func (c *Client) library(ch *library.Change) []string {
+	lines := make([]string, 0, len(ch.Added)+len(ch.Removed)+1)
+	lines = append(lines, serialize.Map(map[string]interface{}{
+		"added":   len(ch.Added),
+		"removed": len(ch.Removed),
+	}))
+	for _, p := range ch.Removed {
+		lines = append(lines, serialize.Map(map[string]interface{}{
+			"path": p,
+		}))
+	}
+	for _, t := range ch.Added {
+		lines = append(lines, serialize.Track(t))
+	}
+
+	return lines
+}
 
-// func (c *Client) playlist(name string, tracks []*vfs.Track) {
-// 	c.conn.WriteLine(name)
-// 	for _, t := range tracks {
-// 		c.conn.WriteLine(serialize.Track(t))
-// 	}
-// }
+func (c *Client) playlists(ch *player.PlaylistsChange) []string {
+	l := responseLine{"action": ch.Action, "name": ch.Name}
+	if ch.Action == player.ActionRenamed {
+		l["new-name"] = ch.NewName
+	}
+
+	return []string{serialize.Map(l)}
+}
+
+func serializeLines(lines []responseLine) []string {
+	s := make([]string, 0, len(lines))
+	for _, l := range lines {
+		s = append(s, serialize.Map(l))
+	}
+
+	return s
+}
 
 func (c *Client) status(st *player.Status) []responseLine {
+	var lines []responseLine
+
 	if st.State == player.StateStopped {
-		return []responseLine{{"state": "stopped"}}
+		lines = []responseLine{
+			{"state": "stopped"},
+			{"mode": st.Mode.String()},
+			{"volume": st.Volume},
+			{"crossfade": st.Crossfade},
+			{"replaygain": st.ReplayGain.String()},
+		}
 	} else {
 		s := ""
 		if st.State == player.StatePlaying {
@@ -131,8 +444,12 @@ func (c *Client) status(st *player.Status) []responseLine {
 
 		track := st.Plist.Get(st.PlistPos)
 
-		return []responseLine{
+		lines = []responseLine{
 			{"state": s},
+			{"mode": st.Mode.String()},
+			{"volume": st.Volume},
+			{"crossfade": st.Crossfade},
+			{"replaygain": st.ReplayGain.String()},
 			{"playlist-position": st.PlistPos},
 			{"track-position": st.Pos},
 			{"playlist-name": st.Plist.Name()},
@@ -145,5 +462,18 @@ func (c *Client) status(st *player.Status) []responseLine {
 			{"track-number": track.Tag.Number},
 			{"track-length": track.Length},
 		}
+		if p := track.Properties; p != nil {
+			lines = append(lines,
+				responseLine{"track-codec": p.Codec},
+				responseLine{"track-bitrate": p.Bitrate},
+				responseLine{"track-bit-depth": p.BitDepth},
+				responseLine{"track-sample-rate": p.SampleRate},
+				responseLine{"track-channels": p.Channels})
+		}
+	}
+	if st.LastError != "" {
+		lines = append(lines, responseLine{"last-error": st.LastError})
 	}
+
+	return lines
 }
@@ -19,21 +19,37 @@ package notif
 
 import (
 	"net"
+	"time"
 
 	"github.com/vchimishuk/chub/cnet"
+	"github.com/vchimishuk/chub/library"
 	"github.com/vchimishuk/chub/player"
 )
 
 type Server struct {
-	srv *cnet.Server
+	srv    *cnet.Server
+	player *player.Player
+	// Position event interval. Zero disables position events.
+	tick time.Duration
+	stop chan struct{}
 }
 
-func NewServer(p *player.Player) *Server {
+// NewServer returns new notification server. Clients subscribed to
+// position event receive it every tick interval while playing.
+func NewServer(p *player.Player, lib *library.Library, tick time.Duration) *Server {
 	srv := cnet.NewServer(func(conn net.Conn, s *cnet.Server) cnet.Client {
-		return NewClient(conn)
+		return NewClient(conn, p)
+	})
+	s := &Server{
+		srv:    srv,
+		player: p,
+		tick:   tick,
+		stop:   make(chan struct{}),
+	}
+	p.AddEventHandler(s.onEvent)
+	lib.AddChangeHandler(func(ch *library.Change) {
+		s.onEvent(eventLibrary, []interface{}{ch})
 	})
-	s := &Server{srv}
-	p.SetEventHandler(s.onEvent)
 
 	return s
 }
@@ -43,13 +59,47 @@ func (s *Server) Listen(addr string, port int) error {
 }
 
 func (s *Server) Serve() {
+	if s.tick > 0 {
+		go s.ticker()
+	}
 	s.srv.Serve()
 }
 
 func (s *Server) Close() {
+	close(s.stop)
 	s.srv.Close()
 }
 
+// ticker sends position event to subscribed clients while playing.
+func (s *Server) ticker() {
+	t := time.NewTicker(s.tick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			var clients []*Client
+			for _, c := range s.srv.Clients() {
+				if c.(*Client).Subscribed(eventPosition) {
+					clients = append(clients, c.(*Client))
+				}
+			}
+			if len(clients) == 0 {
+				continue
+			}
+			st := s.player.Status()
+			if st.State != player.StatePlaying {
+				continue
+			}
+			for _, c := range clients {
+				c.Notify(eventPosition, []interface{}{st})
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
 func (s *Server) onEvent(e player.Event, args []interface{}) {
 	for _, c := range s.srv.Clients() {
 		c.(*Client).Notify(e, args)
@@ -0,0 +1,646 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+// store package implements persistent storage for user playlists and
+// player state, so they survive daemon restarts.
+//
+// Storage directory layout is the following:
+//
+// playlists/NAME.m3u8 -- user playlist, one file per playlist. NAME is
+// URL path-escaped playlist name. Every file is an extended M3U file in
+// UTF-8 with one VFS path (including :N CUE track suffix) per line.
+// Path is preceded by #EXTINF line with the track length and
+// #CHUB-ID line with the track audio fingerprint (see
+// vfs.Track.Fingerprint). Fingerprints of new tracks are computed in
+// background by AutoSave, so #CHUB-ID can be absent until the next
+// save. Tracks which are not found by path anymore
+// are looked up by the fingerprint, so playlists survive moving and
+// renaming of music files and directories. Tracks which are not found
+// either are kept as missing ones (see vfs.MissingTrack) and written
+// back unchanged, so they can be found later.
+//
+// current.m3u8 -- VFS playlist (one started with PLAY command) which
+// was active on shutdown. Absent if a user playlist was active.
+//
+// state -- player state in key=value format (see config package):
+// playlist name, track position, offset in seconds inside the track,
+// playback state, volume and mode.
+//
+// All files are written atomically: data goes into a temporary file
+// which replaces the original one with rename(2).
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vchimishuk/chub/config"
+	"github.com/vchimishuk/chub/logger"
+	"github.com/vchimishuk/chub/player"
+	"github.com/vchimishuk/chub/vfs"
+)
+
+const (
+	playlistsDir = "playlists"
+	playlistExt  = ".m3u8"
+	currentFile  = "current" + playlistExt
+	stateFile    = "state"
+	m3uHeader    = "#EXTM3U"
+	m3uInfo      = "#EXTINF:"
+	m3uID        = "#CHUB-ID:"
+	// Delay between the last change and saving it by AutoSave,
+	// so a burst of changes is saved at once.
+	saveDelay = 2 * time.Second
+)
+
+// State describes player state to be restored on startup.
+type State struct {
+	// Active playlist name.
+	Playlist string
+	// Active track position in the playlist.
+	Position int
+	// Position inside the active track in seconds.
+	Offset int
+	// Playback state.
+	State player.State
+	// Volume level.
+	Volume int
+	// Playback mode.
+	Mode player.Mode
+}
+
+// Resolver returns track with the given fingerprint and length or nil
+// if there is no such track.
+type Resolver func(id string, length int) *vfs.Track
+
+type Store struct {
+	dir     string
+	resolve Resolver
+	// Mutex serializes ResolveMissing calls.
+	resolveMu sync.Mutex
+	// Mutex serializes fingerprint calls.
+	fpMu sync.Mutex
+	// Mutex guards ids field.
+	idsMu sync.Mutex
+	// Fingerprints of playlist tracks by path computed by
+	// fingerprint. The map is replaced, never modified.
+	ids map[string]string
+	// Mutex serializes Save calls.
+	saveMu sync.Mutex
+	// Mutex guards timer field.
+	timerMu sync.Mutex
+	// Pending AutoSave save.
+	timer *time.Timer
+}
+
+// New returns store which keeps its files in the given directory.
+// Directory is created if it does not exist.
+func New(dir string) (*Store, error) {
+	err := os.MkdirAll(filepath.Join(dir, playlistsDir), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+// SetResolver sets resolver used to find playlist tracks which
+// paths are not valid anymore.
+func (s *Store) SetResolver(r Resolver) {
+	s.resolve = r
+}
+
+// SavePlaylists writes all given playlists replacing previously
+// stored ones.
+func (s *Store) SavePlaylists(plists []*player.Playlist) error {
+	names := make(map[string]bool)
+	ids := s.trackIDs()
+	for _, pl := range plists {
+		f := playlistFile(pl.Name())
+		err := writeFile(filepath.Join(s.dir, playlistsDir, f),
+			encodePlaylist(pl, ids))
+		if err != nil {
+			return err
+		}
+		names[f] = true
+	}
+
+	// Remove deleted playlists.
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, playlistsDir))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !names[f.Name()] && strings.HasSuffix(f.Name(), playlistExt) {
+			err := os.Remove(filepath.Join(s.dir, playlistsDir, f.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadPlaylists reads all stored playlists. Tracks which cannot be found
+// in VFS by path are resolved by fingerprint, the ones which cannot be
+// found either are loaded as missing tracks.
+func (s *Store) LoadPlaylists() ([]*player.Playlist, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, playlistsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	plists := make([]*player.Playlist, 0, len(files))
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), playlistExt) {
+			continue
+		}
+		name, err := url.PathUnescape(strings.TrimSuffix(f.Name(),
+			playlistExt))
+		if err != nil {
+			logger.Warning("invalid playlist file name %s", f.Name())
+			continue
+		}
+		pl, err := s.readPlaylist(name,
+			filepath.Join(s.dir, playlistsDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		plists = append(plists, pl)
+	}
+
+	return plists, nil
+}
+
+// SaveState writes player state and the active playlist if it is
+// not one of user playlists.
+func (s *Store) SaveState(st *State, current *player.Playlist) error {
+	cur := filepath.Join(s.dir, currentFile)
+	if current != nil {
+		err := writeFile(cur, encodePlaylist(current, s.trackIDs()))
+		if err != nil {
+			return err
+		}
+	} else {
+		err := os.Remove(cur)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	var b bytes.Buffer
+	// Name is quoted to keep surrounding spaces and special
+	// characters.
+	fmt.Fprintf(&b, "playlist = %s\n", strconv.Quote(st.Playlist))
+	fmt.Fprintf(&b, "position = %d\n", st.Position)
+	fmt.Fprintf(&b, "offset = %d\n", st.Offset)
+	fmt.Fprintf(&b, "state = %s\n", stateName(st.State))
+	fmt.Fprintf(&b, "volume = %d\n", st.Volume)
+	fmt.Fprintf(&b, "mode = %s\n", st.Mode)
+
+	return writeFile(filepath.Join(s.dir, stateFile), b.Bytes())
+}
+
+// LoadState reads player state and the active playlist if it was
+// saved. Nil state is returned if there is no saved state.
+func (s *Store) LoadState() (*State, *player.Playlist, error) {
+	cfg, err := config.ParseFile(filepath.Join(s.dir, stateFile))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st := &State{Playlist: cfg.String("playlist", "")}
+	if name, err := strconv.Unquote(st.Playlist); err == nil {
+		st.Playlist = name
+	}
+	st.Position, err = cfg.Int("position", 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	st.Offset, err = cfg.Int("offset", 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	st.State = parseState(cfg.String("state", ""))
+	st.Volume, err = cfg.Int("volume", player.MaxVolume)
+	if err != nil {
+		return nil, nil, err
+	}
+	st.Mode, err = player.ParseMode(cfg.String("mode",
+		player.ModeOff.String()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var current *player.Playlist
+	cur := filepath.Join(s.dir, currentFile)
+	if _, err := os.Stat(cur); err == nil {
+		current, err = s.readPlaylist(st.Playlist, cur)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return st, current, nil
+}
+
+// AutoSave saves playlists and the player state shortly after they
+// change, so changes survive a crash. Fingerprints of new playlist
+// tracks are computed after the save and saved too. Save still has to
+// be called on shutdown to store the final state.
+func (s *Store) AutoSave(p *player.Player) {
+	p.AddEventHandler(func(e player.Event, args []interface{}) {
+		switch e {
+		case player.EventPlaylist, player.EventPlaylists,
+			player.EventStatus:
+			s.schedule(p)
+		}
+	})
+}
+
+// schedule saves the player after saveDelay unless another change
+// comes earlier.
+func (s *Store) schedule(p *player.Player) {
+	s.timerMu.Lock()
+	defer s.timerMu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Reset(saveDelay)
+		return
+	}
+	s.timer = time.AfterFunc(saveDelay, func() {
+		err := s.Save(p)
+		if err == nil && s.fingerprint(p) {
+			err = s.Save(p)
+		}
+		if err != nil {
+			logger.Error("failed to save state: %s", err)
+		}
+	})
+}
+
+// fingerprint computes fingerprints of playlist tracks which do not
+// have them. It returns true if any new fingerprint is computed.
+func (s *Store) fingerprint(p *player.Player) bool {
+	s.fpMu.Lock()
+	defer s.fpMu.Unlock()
+
+	plists := p.Playlists()
+	if cur := p.Status().Plist; cur != nil {
+		plists = append(plists, cur)
+	}
+	known := s.trackIDs()
+	// Fingerprints of removed tracks are dropped.
+	ids := make(map[string]string)
+	computed := false
+	for _, pl := range plists {
+		for i := 0; i < pl.Len(); i++ {
+			t := pl.Get(i)
+			if t.ID != "" || t.Missing {
+				continue
+			}
+			path := t.Path.String()
+			if _, ok := ids[path]; ok {
+				continue
+			}
+			id, ok := known[path]
+			if !ok {
+				var err error
+				id, err = t.Fingerprint()
+				if err != nil {
+					// File can be unavailable for a while,
+					// so try again on the next pass.
+					logger.Warning("%s: fingerprint: %s",
+						path, err)
+					continue
+				}
+				computed = true
+			}
+			ids[path] = id
+		}
+	}
+
+	s.idsMu.Lock()
+	s.ids = ids
+	s.idsMu.Unlock()
+
+	return computed
+}
+
+// trackIDs returns fingerprints computed by fingerprint.
+func (s *Store) trackIDs() map[string]string {
+	s.idsMu.Lock()
+	defer s.idsMu.Unlock()
+
+	return s.ids
+}
+
+// Save stores user playlists and the player state. It does not compute
+// missing track fingerprints, so it is fast enough for shutdown.
+func (s *Store) Save(p *player.Player) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	err := s.SavePlaylists(p.Playlists())
+	if err != nil {
+		return err
+	}
+
+	st := p.Status()
+	state := &State{
+		Position: st.PlistPos,
+		Offset:   st.Pos,
+		State:    st.State,
+		Volume:   st.Volume,
+		Mode:     st.Mode,
+	}
+	var current *player.Playlist
+	if st.Plist != nil {
+		state.Playlist = st.Plist.Name()
+		if _, err := p.Playlist(st.Plist.Name()); err != nil {
+			// Not a user playlist, so has to be stored separately.
+			current = st.Plist
+		}
+	}
+
+	return s.SaveState(state, current)
+}
+
+// ResolveMissing looks for missing playlist tracks again and replaces
+// found ones in the player playlists. It is supposed to be called when
+// the library changes, e.g. after the first library update or when
+// music directory is mounted again.
+func (s *Store) ResolveMissing(p *player.Player) {
+	s.resolveMu.Lock()
+	defer s.resolveMu.Unlock()
+
+	plists := p.Playlists()
+	if cur := p.Status().Plist; cur != nil {
+		plists = append(plists, cur)
+	}
+	seen := make(map[*vfs.Track]bool)
+	found := make(map[*vfs.Track]*vfs.Track)
+	for _, pl := range plists {
+		for i := 0; i < pl.Len(); i++ {
+			t := pl.Get(i)
+			if !t.Missing || seen[t] {
+				continue
+			}
+			seen[t] = true
+			ft, err := findTrack(pl.Name(), t.Path.String(), t.ID,
+				t.Length, s.resolve)
+			if err == nil {
+				found[t] = ft
+			}
+		}
+	}
+	if len(found) > 0 {
+		p.ReplaceTracks(found)
+	}
+}
+
+// Restore loads user playlists and the player state saved by Save.
+func (s *Store) Restore(p *player.Player) error {
+	plists, err := s.LoadPlaylists()
+	if err != nil {
+		return err
+	}
+	for _, pl := range plists {
+		err := p.AddPlaylist(pl)
+		if err != nil {
+			return err
+		}
+	}
+
+	st, current, err := s.LoadState()
+	if err != nil || st == nil {
+		return err
+	}
+	err = p.SetVolume(st.Volume, false)
+	if err != nil {
+		return err
+	}
+	// SetMode returns after the mode is applied, so resumed playlist
+	// is shuffled in random mode.
+	p.SetMode(st.Mode)
+
+	if st.State != player.StateStopped {
+		pl := current
+		if pl == nil {
+			pl, err = p.Playlist(st.Playlist)
+			if err != nil {
+				return err
+			}
+		}
+		paused := st.State == player.StatePaused
+		err = p.Resume(pl, st.Position, st.Offset, paused)
+		if err != nil {
+			// Playlist could be changed since the last run.
+			logger.Warning("unable to resume playback: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func playlistFile(name string) string {
+	return url.PathEscape(name) + playlistExt
+}
+
+// encodePlaylist returns playlist file data. Fingerprints of tracks
+// which do not know them are taken from ids by path.
+func encodePlaylist(pl *player.Playlist, ids map[string]string) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(m3uHeader + "\n")
+	for i := 0; i < pl.Len(); i++ {
+		t := pl.Get(i)
+		title := t.Path.Base()
+		if t.Tag != nil && t.Tag.Title != "" {
+			title = t.Tag.Title
+			if t.Tag.Artist != "" {
+				title = t.Tag.Artist + " - " + title
+			}
+		}
+		fmt.Fprintf(&b, "%s%d,%s\n", m3uInfo, t.Length, title)
+		id := t.ID
+		if id == "" {
+			id = ids[t.Path.String()]
+		}
+		if id != "" {
+			b.WriteString(m3uID + id + "\n")
+		}
+		b.WriteString(t.Path.String() + "\n")
+	}
+
+	return b.Bytes()
+}
+
+func (s *Store) readPlaylist(name string, file string) (*player.Playlist, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return decodePlaylist(name, f, s.resolve)
+}
+
+// decodePlaylist reads playlist file. Tracks which paths are not valid
+// anymore are resolved by fingerprint with the resolver if it is
+// not nil. Tracks which are not found are returned as missing ones.
+func decodePlaylist(name string, r io.Reader, resolve Resolver) (*player.Playlist, error) {
+	var tracks []*vfs.Track
+	// #EXTINF and #CHUB-ID values of the next track.
+	length := -1
+	title := ""
+	id := ""
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, m3uInfo) {
+			l := strings.SplitN(strings.TrimPrefix(line, m3uInfo), ",", 2)
+			length, _ = strconv.Atoi(l[0])
+			if len(l) > 1 {
+				title = l[1]
+			}
+			continue
+		}
+		if strings.HasPrefix(line, m3uID) {
+			id = strings.TrimPrefix(line, m3uID)
+			continue
+		}
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		t, err := findTrack(name, line, id, length, resolve)
+		if err != nil {
+			logger.Warning("playlist %s: %s: %s", name, line, err)
+			t = vfs.MissingTrack(line)
+			t.ID = id
+			if length > 0 {
+				t.Length = length
+			}
+			if title != "" {
+				t.Tag.Title = title
+			}
+		}
+		tracks = append(tracks, t)
+		length = -1
+		title = ""
+		id = ""
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return player.NewPlaylist(name).Append(tracks...), nil
+}
+
+// findTrack returns playlist track by its path or, if the path is not
+// valid anymore, by its fingerprint with the resolver if it is not nil.
+func findTrack(plist string, path string, id string, length int,
+	resolve Resolver) (*vfs.Track, error) {
+
+	t, err := playlistTrack(path)
+	if err == nil {
+		t.ID = id
+		return t, nil
+	}
+	if id != "" && resolve != nil {
+		if rt := resolve(id, length); rt != nil {
+			logger.Info("playlist %s: %s moved to %s",
+				plist, path, rt.Path)
+			rt := *rt
+			rt.ID = id
+			return &rt, nil
+		}
+	}
+
+	return nil, err
+}
+
+func playlistTrack(path string) (*vfs.Track, error) {
+	p, err := vfs.NewPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Track()
+}
+
+// writeFile atomically replaces file with the given data.
+func writeFile(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file),
+		"."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+func stateName(s player.State) string {
+	switch s {
+	case player.StatePlaying:
+		return "playing"
+	case player.StatePaused:
+		return "paused"
+	default:
+		return "stopped"
+	}
+}
+
+func parseState(s string) player.State {
+	switch s {
+	case "playing":
+		return player.StatePlaying
+	case "paused":
+		return player.StatePaused
+	default:
+		return player.StateStopped
+	}
+}
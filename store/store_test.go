@@ -0,0 +1,330 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vchimishuk/chub/player"
+	"github.com/vchimishuk/chub/vfs"
+)
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _, err := s.LoadState()
+	if err != nil || st != nil {
+		t.Fatal()
+	}
+
+	expected := &State{
+		Playlist: "Heavy Metal",
+		Position: 3,
+		Offset:   125,
+		State:    player.StatePaused,
+		Volume:   42,
+		Mode:     player.ModeRandom,
+	}
+	err = s.SaveState(expected, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, cur, err := s.LoadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur != nil {
+		t.Fatal()
+	}
+	if *st != *expected {
+		t.Fatalf("%v expected but %v got", expected, st)
+	}
+
+	// Playlist name is kept as is.
+	for _, name := range []string{"  jazz ", "a = b\nc", `"quoted"`, ""} {
+		expected.Playlist = name
+		err = s.SaveState(expected, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, _, err = s.LoadState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Playlist != name {
+			t.Fatalf("%q expected but %q got", name, st.Playlist)
+		}
+	}
+}
+
+func TestPlaylists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SavePlaylists([]*player.Playlist{
+		player.NewPlaylist("foo/bar"),
+		player.NewPlaylist("baz"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SavePlaylists([]*player.Playlist{
+		player.NewPlaylist("foo/bar"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plists, err := s.LoadPlaylists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plists) != 1 || plists[0].Name() != "foo/bar" {
+		t.Fatal()
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, playlistsDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No temporary files expected to be left.
+	if len(files) != 1 || files[0].Name() != "foo%2Fbar.m3u8" {
+		t.Fatal()
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = vfs.SetRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "new.mp3"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := vfs.NewPath("/new.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := &vfs.Track{Path: p, Length: 215}
+
+	data := m3uHeader + "\n" +
+		m3uInfo + "215,Doro - Fight\n" +
+		m3uID + "abc\n" +
+		"/old.mp3\n" +
+		m3uInfo + "100,Lost\n" +
+		m3uID + "def\n" +
+		"/lost.mp3\n"
+	pl, err := decodePlaylist("test", strings.NewReader(data),
+		func(id string, length int) *vfs.Track {
+			if id == "abc" && length == 215 {
+				return moved
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Len() != 2 || pl.Get(0).Path.String() != "/new.mp3" ||
+		pl.Get(0).ID != "abc" || moved.ID != "" {
+		t.Fatal()
+	}
+	lost := pl.Get(1)
+	if !lost.Missing || lost.Path.String() != "/lost.mp3" ||
+		lost.ID != "def" || lost.Length != 100 {
+		t.Fatal()
+	}
+
+	// Known fingerprint is saved along with the new path and
+	// missing track is saved unchanged.
+	enc := string(encodePlaylist(pl, make(map[string]string)))
+	if !strings.HasSuffix(enc, m3uID+"abc\n/new.mp3\n"+
+		m3uInfo+"100,Lost\n"+m3uID+"def\n/lost.mp3\n") {
+		t.Fatalf("unexpected playlist %q", enc)
+	}
+}
+
+func TestResolveMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = vfs.SetRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "new.mp3"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := vfs.NewPath("/new.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := &vfs.Track{Path: p, Length: 215}
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := player.New(nil, nil, player.NewSoftMixer())
+	defer pl.Close()
+	missing := vfs.MissingTrack("/old.mp3")
+	missing.ID = "abc"
+	missing.Length = 215
+	err = pl.AddPlaylist(player.NewPlaylist("test").Append(missing))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Library is not loaded yet.
+	found := false
+	s.SetResolver(func(id string, length int) *vfs.Track {
+		if found && id == "abc" && length == 215 {
+			return moved
+		}
+		return nil
+	})
+	s.ResolveMissing(pl)
+	plist, err := pl.Playlist("test")
+	if err != nil || plist.Get(0) != missing {
+		t.Fatal()
+	}
+
+	found = true
+	s.ResolveMissing(pl)
+	plist, err = pl.Playlist("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr := plist.Get(0); tr.Missing || tr.Path.String() != "/new.mp3" ||
+		tr.ID != "abc" {
+		t.Fatal()
+	}
+}
+
+func TestAutoSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := player.New(nil, nil, player.NewSoftMixer())
+	defer p.Close()
+	s.AutoSave(p)
+	err = p.Create("Heavy Metal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, playlistsDir, "Heavy%20Metal.m3u8")
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(file); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("playlist is not saved")
+}
+
+func TestFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = vfs.SetRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "a.mp3"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := vfs.NewPath("/a.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := player.New(nil, nil, player.NewSoftMixer())
+	defer pl.Close()
+	plist := player.NewPlaylist("test").Append(&vfs.Track{Path: p})
+	err = pl.AddPlaylist(plist)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Known fingerprints are kept, the ones of removed tracks
+	// are dropped.
+	s.ids = map[string]string{"/a.mp3": "abc", "/gone.mp3": "def"}
+	if s.fingerprint(pl) {
+		t.Fatal()
+	}
+	ids := s.trackIDs()
+	if len(ids) != 1 || ids["/a.mp3"] != "abc" {
+		t.Fatalf("unexpected fingerprints %v", ids)
+	}
+	enc := string(encodePlaylist(plist, ids))
+	if !strings.Contains(enc, m3uID+"abc\n/a.mp3\n") {
+		t.Fatalf("unexpected playlist %q", enc)
+	}
+
+	// Failed fingerprint is not cached, so it is retried later.
+	s.ids = nil
+	if s.fingerprint(pl) {
+		t.Fatal()
+	}
+	if _, ok := s.trackIDs()["/a.mp3"]; ok {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,447 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/vchimishuk/chub/cue"
+	"github.com/vchimishuk/chub/format"
+)
+
+// GapMode defines how CUE track gaps are played. Gap is the audio
+// between INDEX 00 and INDEX 01 of the track.
+type GapMode int
+
+const (
+	// Gap is played at the end of the previous track.
+	GapAppend GapMode = iota
+	// Gap is played at the beginning of the track it belongs to.
+	// If the gap is in the previous track's file it is played at
+	// the end of the previous track.
+	GapPrepend
+	// Gaps are not played, PREGAP and POSTGAP silence too.
+	GapSkip
+)
+
+var gapModeNames = map[GapMode]string{
+	GapAppend:  "append",
+	GapPrepend: "prepend",
+	GapSkip:    "skip",
+}
+
+func (m GapMode) String() string {
+	return gapModeNames[m]
+}
+
+// ParseGapMode returns GapMode by its string name.
+func ParseGapMode(s string) (GapMode, error) {
+	for m, name := range gapModeNames {
+		if name == s {
+			return m, nil
+		}
+	}
+
+	return GapAppend, errors.New("invalid gap mode")
+}
+
+// HTOAMode defines how hidden track one audio (HTOA) is played. HTOA
+// is the audio preceding INDEX 01 of the first track.
+type HTOAMode int
+
+const (
+	// HTOA is not played.
+	HTOASkip HTOAMode = iota
+	// HTOA is played at the beginning of the first track.
+	HTOAPrepend
+	// HTOA is a separate track with number 0.
+	HTOATrack
+)
+
+var htoaModeNames = map[HTOAMode]string{
+	HTOASkip:    "skip",
+	HTOAPrepend: "prepend",
+	HTOATrack:   "track",
+}
+
+func (m HTOAMode) String() string {
+	return htoaModeNames[m]
+}
+
+// ParseHTOAMode returns HTOAMode by its string name.
+func ParseHTOAMode(s string) (HTOAMode, error) {
+	for m, name := range htoaModeNames {
+		if name == s {
+			return m, nil
+		}
+	}
+
+	return HTOASkip, errors.New("invalid HTOA mode")
+}
+
+var gapMode = GapAppend
+var htoaMode = HTOASkip
+
+// SetGapMode sets CUE gaps handling mode.
+func SetGapMode(m GapMode) {
+	gapMode = m
+}
+
+// SetHTOAMode sets hidden track one audio handling mode.
+func SetHTOAMode(m HTOAMode) {
+	htoaMode = m
+}
+
+// cueCharsetFile is the name of a file containing charset name of
+// CUE sheets in its directory and all subdirectories.
+const cueCharsetFile = ".cue-charset"
+
+var cueCharset = ""
+
+// SetCueCharset sets charset of CUE sheets which are neither in UTF-8
+// nor in UTF-16. Empty charset means detect.
+func SetCueCharset(charset string) {
+	cueCharset = charset
+}
+
+// parseCueSheet parses CUE sheet file in the charset given by the
+// nearest charset file or the default one.
+func parseCueSheet(p *Path) (*cue.Sheet, error) {
+	return cue.ParseFileCharset(p.File(), 0, cueSheetCharset(p))
+}
+
+func cueSheetCharset(p *Path) string {
+	for dir := path.Dir(p.Val()); ; dir = path.Dir(dir) {
+		b, err := ioutil.ReadFile(filePath(p.root,
+			path.Join(dir, cueCharsetFile)))
+		if err == nil {
+			if cs := strings.TrimSpace(string(b)); cs != "" {
+				return cs
+			}
+		}
+		if dir == "/" {
+			return cueCharset
+		}
+	}
+}
+
+// cueSheetTag is the name of the tag FLAC and APE files embed
+// CUE sheet into.
+const cueSheetTag = "cuesheet"
+
+// embeddedCueSheet returns CUE sheet embedded into the file metadata
+// or nil if there is no one. The sheet describes the file whatever
+// file name it contains, disc title and performer are taken from
+// the file tags if the sheet has none.
+func embeddedCueSheet(p *Path, md format.Metadata) (*cue.Sheet, error) {
+	tags := md.Tags()
+	for name, value := range tags {
+		if strings.ToLower(name) != cueSheetTag {
+			continue
+		}
+		sheet, err := cue.ParseCharset(strings.NewReader(value), 0,
+			cueCharset)
+		if err != nil {
+			return nil, err
+		}
+		if len(sheet.Files) != 1 {
+			return nil, errors.New("embedded CUE sheet must describe one file")
+		}
+		sheet.Files[0].Name = p.Base()
+
+		tag := NewTag(tags)
+		if len(sheet.Title) == 0 {
+			sheet.Title = tag.Album
+		}
+		if len(sheet.Performer) == 0 {
+			sheet.Performer = tag.AlbumArtist
+		}
+		if len(sheet.Performer) == 0 {
+			sheet.Performer = tag.Artist
+		}
+
+		return sheet, nil
+	}
+
+	return nil, nil
+}
+
+// CueSheet returns CUE sheet describing all tracks of the directory.
+// Tracks are numbered sequentially, disc title, performer, genre and
+// date are set if they are the same for all the tracks.
+func (p *Path) CueSheet() (*cue.Sheet, error) {
+	if !p.IsDir() {
+		return nil, fmt.Errorf("'%s' is not directory", p)
+	}
+	entries, err := readTracks(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no tracks found")
+	}
+
+	return newCueSheet(entries), nil
+}
+
+func newCueSheet(entries []Entry) *cue.Sheet {
+	sheet := &cue.Sheet{}
+
+	first := entries[0].Track().Tag
+	title, performer := first.Album, albumArtist(first)
+	genre, date := first.Genre, first.Date
+	for _, e := range entries {
+		tag := e.Track().Tag
+		if tag.Album != title {
+			title = ""
+		}
+		if albumArtist(tag) != performer {
+			performer = ""
+		}
+		if tag.Genre != genre {
+			genre = ""
+		}
+		if tag.Date != date {
+			date = ""
+		}
+	}
+	sheet.Title = title
+	sheet.Performer = performer
+	if len(genre) > 0 {
+		sheet.Comments = append(sheet.Comments, "GENRE "+genre)
+	}
+	if len(date) > 0 {
+		sheet.Comments = append(sheet.Comments, "DATE "+date)
+	}
+
+	var f *cue.File
+	for i, e := range entries {
+		t := e.Track()
+		if f == nil || f.Name != t.Path.Base() {
+			f = &cue.File{
+				Name: t.Path.Base(),
+				Type: cueFileType(t.Path.Ext()),
+			}
+			sheet.Files = append(sheet.Files, f)
+		}
+		ct := &cue.Track{
+			Number:     i + 1,
+			DataType:   cue.DataTypeAudio,
+			Title:      t.Tag.Title,
+			Performer:  t.Tag.Artist,
+			Songwriter: t.Tag.Composer,
+			Indexes: []*cue.Index{
+				{Number: 1, File: f, Time: cueTime(t.Start)},
+			},
+		}
+		// Parser rejects malformed ISRC.
+		if len(t.Tag.Isrc) == 12 {
+			ct.Isrc = t.Tag.Isrc
+		}
+		if t.Pregap > 0 {
+			ct.Pregap = cueTime(t.Pregap)
+		}
+		if t.Postgap > 0 {
+			ct.Postgap = cueTime(t.Postgap)
+		}
+		f.Tracks = append(f.Tracks, ct)
+	}
+
+	return sheet
+}
+
+func albumArtist(tag *Tag) string {
+	if len(tag.AlbumArtist) > 0 {
+		return tag.AlbumArtist
+	}
+
+	return tag.Artist
+}
+
+func cueFileType(ext string) cue.FileType {
+	switch ext {
+	case "mp3":
+		return cue.FileTypeMp3
+	case "aif", "aiff":
+		return cue.FileTypeAiff
+	default:
+		return cue.FileTypeWave
+	}
+}
+
+// cueTime returns CUE time of the frames number.
+func cueTime(frames int) *cue.Time {
+	return &cue.Time{
+		Min:    frames / FramesPerSecond / 60,
+		Sec:    frames / FramesPerSecond % 60,
+		Frames: frames % FramesPerSecond,
+	}
+}
+
+// cueSheetTracks returns all tracks of the CUE sheet.
+func cueSheetTracks(base *Path, sheet *cue.Sheet) ([]Entry, error) {
+	tracks := make([]Entry, 0)
+	// Metadata is read once for all tracks of the file.
+	mds := make(map[string]format.Metadata)
+
+	numbers := []int{0}
+	for _, t := range sheet.Tracks() {
+		numbers = append(numbers, t.Number)
+	}
+	for _, n := range numbers {
+		t, err := cueSheetTrack(base, sheet, n, mds)
+		if err == nil {
+			tracks = append(tracks, t)
+		}
+	}
+
+	return tracks, nil
+}
+
+// cueSheetTrack returns track with the given number of the CUE sheet.
+// Track 0 is the hidden track one audio in HTOATrack mode. mds is
+// the metadata cache for the sheet's files.
+func cueSheetTrack(base *Path, sheet *cue.Sheet, number int,
+	mds map[string]format.Metadata) (*Track, error) {
+
+	tracks := sheet.Tracks()
+	if len(tracks) == 0 {
+		return nil, errors.New("CUE TRACK not found")
+	}
+	if number == 0 {
+		return cueHTOATrack(base, sheet, tracks[0], mds)
+	}
+
+	ti := -1
+	for i, t := range tracks {
+		if t.Number == number {
+			ti = i
+			break
+		}
+	}
+	if ti == -1 {
+		return nil, errors.New("CUE TRACK not found")
+	}
+	t := tracks[ti]
+	i1 := t.Index(1)
+	if i1 == nil {
+		return nil, errors.New("CUE start INDEX not found")
+	}
+	f := i1.File
+
+	start := i1.Time.TotalFrames()
+	if i0 := t.Index(0); i0 != nil && i0.File == f && gapMode == GapPrepend {
+		start = i0.Time.TotalFrames()
+	}
+	if ti == 0 && htoaMode == HTOAPrepend {
+		start = 0
+	}
+
+	// Track lasts till the next track's INDEX 01 or INDEX 00 in the
+	// same file or till the end of the file.
+	end := 0
+	if ti < len(tracks)-1 {
+		next := tracks[ti+1]
+		n1 := next.Index(1)
+		if n1 == nil {
+			return nil, errors.New("CUE start INDEX not found")
+		}
+		if n1.File == f {
+			end = n1.Time.TotalFrames()
+		}
+		n0 := next.Index(0)
+		if n0 != nil && n0.File == f && (gapMode == GapSkip ||
+			gapMode == GapPrepend && n1.File == f) {
+			end = n0.Time.TotalFrames()
+		}
+	}
+
+	var pregap, postgap int
+	if gapMode != GapSkip {
+		if t.Pregap != nil {
+			pregap = t.Pregap.TotalFrames()
+		}
+		if t.Postgap != nil {
+			postgap = t.Postgap.TotalFrames()
+		}
+	}
+
+	return newCueTrack(base, sheet, t, f, start, end, pregap, postgap, mds)
+}
+
+// cueHTOATrack returns hidden track one audio track if there is one
+// and it is played as a separate track.
+func cueHTOATrack(base *Path, sheet *cue.Sheet, first *cue.Track,
+	mds map[string]format.Metadata) (*Track, error) {
+
+	i1 := first.Index(1)
+	if htoaMode != HTOATrack || i1 == nil || i1.Time.TotalFrames() == 0 ||
+		i1.File != sheet.Files[0] {
+		return nil, errors.New("CUE TRACK not found")
+	}
+
+	return newCueTrack(base, sheet, &cue.Track{Number: 0}, i1.File,
+		0, i1.Time.TotalFrames(), 0, 0, mds)
+}
+
+// newCueTrack returns track which is a part of the file f from start
+// to end frames, end 0 is the end of the file.
+func newCueTrack(base *Path, sheet *cue.Sheet, t *cue.Track, f *cue.File,
+	start int, end int, pregap int, postgap int,
+	mds map[string]format.Metadata) (*Track, error) {
+
+	pth, err := base.ChildPart(f.Name, t.Number)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := mds[f.Name]
+	if !ok {
+		// TODO: Register formats in VFS package.
+		md, _ = format.GetMetadata(filepath.Join(base.File(), f.Name))
+		mds[f.Name] = md
+	}
+
+	var length int
+	if end != 0 {
+		length = (end - start) / FramesPerSecond
+	} else {
+		if md == nil {
+			return nil, errors.New("failed to read metadata")
+		}
+		length = md.Length() - start/FramesPerSecond
+	}
+	length += (pregap + postgap) / FramesPerSecond
+
+	return &Track{
+		Path:       pth,
+		Tag:        newTag(sheet, t),
+		Properties: newProperties(md),
+		Length:     length,
+		Part:       true,
+		Number:     t.Number,
+		Start:      start,
+		End:        end,
+		Pregap:     pregap,
+		Postgap:    postgap,
+	}, nil
+}
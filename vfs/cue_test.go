@@ -0,0 +1,327 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vchimishuk/chub/cue"
+	"github.com/vchimishuk/chub/format"
+)
+
+// testFormat is a FLAC format which files are 10 minutes long.
+// embedded.flac file has CUE sheet in its tags.
+type testFormat struct{}
+
+func (testFormat) Extensions() []string {
+	return []string{"flac"}
+}
+
+func (testFormat) Metadata(path string) (format.Metadata, error) {
+	if filepath.Base(path) == "embedded.flac" {
+		return testMetadata{map[string]string{
+			"ARTIST":   "Doro",
+			"ALBUM":    "Fight",
+			"CUESHEET": embeddedSheet,
+		}}, nil
+	}
+
+	return testMetadata{}, nil
+}
+
+func (testFormat) Picture(path string) (*format.Picture, error) {
+	return nil, nil
+}
+
+func (testFormat) Decoder(path string) (format.Decoder, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &testDecoder{data: data}, nil
+}
+
+// testDecoder decodes file contents as 44100 Hz stereo PCM data.
+type testDecoder struct {
+	data []byte
+	pos  int64
+}
+
+func (d *testDecoder) Read(buf []byte) (int, error) {
+	if d.pos*4 >= int64(len(d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(buf, d.data[d.pos*4:])
+	n -= n % 4
+	d.pos += int64(n / 4)
+
+	return n, nil
+}
+
+func (d *testDecoder) Seek(pos int, rel bool) error { return nil }
+func (d *testDecoder) Time() int                    { return int(d.pos / 44100) }
+func (d *testDecoder) Position() int64              { return d.pos }
+func (d *testDecoder) SeekPosition(pos int64) error { d.pos = pos; return nil }
+func (d *testDecoder) SampleRate() int              { return 44100 }
+func (d *testDecoder) Channels() int                { return 2 }
+func (d *testDecoder) Close()                       {}
+
+type testMetadata struct {
+	tags map[string]string
+}
+
+func (m testMetadata) Tags() map[string]string { return m.tags }
+func (testMetadata) Length() int               { return 600 }
+func (testMetadata) Codec() string             { return "flac" }
+func (testMetadata) Bitrate() int              { return 1000 }
+func (testMetadata) BitDepth() int             { return 16 }
+func (testMetadata) SampleRate() int           { return 44100 }
+func (testMetadata) Channels() int             { return 2 }
+
+const embeddedSheet = "FILE \"CDImage.wav\" WAVE\n" +
+	"  TRACK 01 AUDIO\n" +
+	"    TITLE \"Always Live to Win\"\n" +
+	"    INDEX 01 00:00:00\n" +
+	"  TRACK 02 AUDIO\n" +
+	"    TITLE \"Fight\"\n" +
+	"    INDEX 01 03:30:15\n"
+
+// cueTracks returns tracks of the CUE sheet as a "file:number" to
+// [start, end, pregap, postgap] map.
+func cueTracks(t *testing.T, name string) map[string][4]int {
+	sheet, err := cue.ParseFile(filepath.Join("..", "cue", name), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := NewPath("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := cueSheetTracks(base, sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracks := make(map[string][4]int)
+	for _, e := range entries {
+		tr := e.Track()
+		tracks[tr.Path.String()] = [4]int{tr.Start, tr.End,
+			tr.Pregap, tr.Postgap}
+	}
+
+	return tracks
+}
+
+func checkCueTracks(t *testing.T, got map[string][4]int, exp map[string][4]int) {
+	if len(got) != len(exp) {
+		t.Fatalf("%v expected but %v got", exp, got)
+	}
+	for p, e := range exp {
+		if got[p] != e {
+			t.Fatalf("%s: %v expected but %v got", p, e, got[p])
+		}
+	}
+}
+
+// testRoot creates VFS root directory with the given files.
+func testRoot(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "chub-vfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	format.Register(testFormat{})
+
+	return dir
+}
+
+func TestCueGaps(t *testing.T) {
+	dir := testRoot(t, map[string]string{
+		"album.flac": "",
+		"01.flac":    "",
+		"02.flac":    "",
+	})
+	defer os.RemoveAll(dir)
+	defer SetGapMode(GapAppend)
+	defer SetHTOAMode(HTOASkip)
+
+	checkCueTracks(t, cueTracks(t, "gaps.cue"), map[string][4]int{
+		"/album.flac:1": {750, 15180, 0, 0},
+		"/album.flac:2": {15180, 31500, 0, 75},
+		"/album.flac:3": {31500, 0, 150, 0},
+	})
+	SetGapMode(GapPrepend)
+	SetHTOAMode(HTOAPrepend)
+	checkCueTracks(t, cueTracks(t, "gaps.cue"), map[string][4]int{
+		"/album.flac:1": {0, 15000, 0, 0},
+		"/album.flac:2": {15000, 31500, 0, 75},
+		"/album.flac:3": {31500, 0, 150, 0},
+	})
+	SetGapMode(GapSkip)
+	SetHTOAMode(HTOATrack)
+	checkCueTracks(t, cueTracks(t, "gaps.cue"), map[string][4]int{
+		"/album.flac:0": {0, 750, 0, 0},
+		"/album.flac:1": {750, 15000, 0, 0},
+		"/album.flac:2": {15180, 31500, 0, 0},
+		"/album.flac:3": {31500, 0, 0, 0},
+	})
+
+	// Gap in the previous file can't be prepended.
+	SetGapMode(GapPrepend)
+	checkCueTracks(t, cueTracks(t, "multifile.cue"), map[string][4]int{
+		"/01.flac:1": {0, 0, 0, 0},
+		"/02.flac:2": {0, 17250, 0, 0},
+		"/02.flac:3": {17250, 0, 0, 0},
+	})
+	SetGapMode(GapSkip)
+	checkCueTracks(t, cueTracks(t, "multifile.cue"), map[string][4]int{
+		"/01.flac:1": {0, 20250, 0, 0},
+		"/02.flac:2": {0, 17250, 0, 0},
+		"/02.flac:3": {17250, 0, 0, 0},
+	})
+
+	data, err := ioutil.ReadFile(filepath.Join("..", "cue", "multifile.cue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "album.cue"), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPath("/02.flac:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := p.Track()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Length != 230 {
+		t.Fatalf("230 expected but %d got", tr.Length)
+	}
+}
+
+func TestCueCharset(t *testing.T) {
+	sheet := "FILE \"album.flac\" WAVE\n" +
+		"  TRACK 01 AUDIO\n" +
+		"    TITLE \"\xca\xe8\xed\xee\"\n" +
+		"    INDEX 01 00:00:00\n"
+	dir := testRoot(t, map[string]string{
+		"a/album.cue":      sheet,
+		"a/album.flac":     "",
+		"a/b/album.cue":    sheet,
+		"a/b/album.flac":   "",
+		"a/b/.cue-charset": "KOI8-R\n",
+	})
+	defer os.RemoveAll(dir)
+	defer SetCueCharset("")
+
+	for _, tt := range []struct {
+		charset string
+		path    string
+		title   string
+	}{
+		{"", "/a/album.flac:1", "Кино"},
+		{"", "/a/b/album.flac:1", "йХМН"},
+		{"KOI8-R", "/a/album.flac:1", "йХМН"},
+	} {
+		SetCueCharset(tt.charset)
+		p, err := NewPath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := p.Track()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.Tag.Title != tt.title {
+			t.Fatalf("'%s' expected but '%s' got", tt.title, tr.Tag.Title)
+		}
+	}
+}
+
+func TestCueSheetEmbedded(t *testing.T) {
+	dir := testRoot(t, map[string]string{
+		"embedded.flac": "",
+		"single.flac":   "",
+	})
+	defer os.RemoveAll(dir)
+
+	p, err := NewPath("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := p.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Track().Path.String())
+	}
+	if len(paths) != 3 || paths[0] != "/embedded.flac:1" ||
+		paths[1] != "/embedded.flac:2" || paths[2] != "/single.flac" {
+		t.Fatalf("unexpected tracks %v", paths)
+	}
+	tr := entries[1].Track()
+	if tr.Tag.Title != "Fight" || tr.Tag.Album != "Fight" ||
+		tr.Tag.Artist != "Doro" || tr.Start != 15765 || tr.End != 0 {
+		t.Fatalf("unexpected track %v", tr)
+	}
+	p, err = NewPath("/embedded.flac:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Track(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = NewPath("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := p.CueSheet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := sheet.Tracks()
+	if len(sheet.Files) != 2 || sheet.Files[0].Name != "embedded.flac" ||
+		sheet.Files[1].Name != "single.flac" || len(tracks) != 3 ||
+		tracks[1].Title != "Fight" || tracks[2].Number != 3 ||
+		*tracks[1].Index(1).Time != (cue.Time{Min: 3, Sec: 30, Frames: 15}) {
+		t.Fatal()
+	}
+}
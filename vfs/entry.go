@@ -17,6 +17,8 @@
 
 package vfs
 
+import "github.com/vchimishuk/chub/cue"
+
 type Entry interface {
 	IsDir() bool
 	Dir() *Dir
@@ -44,16 +46,16 @@ func (d *Dir) Track() *Track {
 	panic(nil)
 }
 
-// Track's tag data.
-type Tag struct {
-	// Artist name.
-	Artist string
-	// Album name.
-	Album string
-	// Track's title.
-	Title string
-	// Track number.
-	Number int
+// Properties describes audio stream of the track's file.
+type Properties struct {
+	// Codec name, e.g. "mp3" or "flac".
+	Codec string
+	// Average bitrate in kbit/s.
+	Bitrate int
+	// Bits per sample, 0 for lossy codecs.
+	BitDepth   int
+	SampleRate int
+	Channels   int
 }
 
 // Track is a filesystem entry structure representing track.
@@ -65,6 +67,8 @@ type Track struct {
 	Path *Path
 	// Track media information.
 	Tag *Tag
+	// Technical properties of the audio stream.
+	Properties *Properties
 	// Track length in seconds.
 	Length int
 	// If Part is true it means this track represents piece of the
@@ -74,12 +78,27 @@ type Track struct {
 	Part bool
 	// Track number as in CUE file.
 	Number int
-	// Track beginning in the physical file.
+	// Track beginning in the physical file in CUE frames
+	// (1/FramesPerSecond of a second).
 	Start int
-	// Track end position in the physical file.
+	// Track end position in the physical file in CUE frames.
+	// 0 means the end of the file.
 	End int
+	// Length of silence played before and after the track in CUE
+	// frames (PREGAP and POSTGAP).
+	Pregap  int
+	Postgap int
+	// Audio fingerprint (see Fingerprint) if it is known. It is not
+	// filled by VFS, but by the ones who keep track references.
+	ID string
+	// Missing is true if the track file is not found (see
+	// MissingTrack).
+	Missing bool
 }
 
+// Number of CUE frames in a second.
+const FramesPerSecond = cue.FramesPerSecond
+
 func (t *Track) IsDir() bool {
 	return false
 }
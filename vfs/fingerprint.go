@@ -0,0 +1,99 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+
+	"github.com/vchimishuk/chub/format"
+)
+
+// Amount of decoded audio data fingerprint is computed over.
+const fingerprintSize = 256 * 1024
+
+// Fingerprint returns identifier of the track audio. Unlike path it
+// stays the same when the file is moved, renamed or retagged, so it can
+// be used to find the track again. Fingerprint is a hash of the
+// beginning of the decoded track audio, leading silence is skipped.
+func (t *Track) Fingerprint() (string, error) {
+	d, err := format.GetDecoder(t.Path.File())
+	if err != nil {
+		return "", err
+	}
+	defer d.Close()
+
+	rate := int64(d.SampleRate())
+	frameSize := int64(d.Channels()) * 2
+	if start := int64(t.Start) * rate / FramesPerSecond; start > 0 {
+		err := d.SeekPosition(start)
+		if err != nil {
+			return "", err
+		}
+	}
+	end := int64(-1)
+	if t.Part && t.End != 0 {
+		end = int64(t.End) * rate / FramesPerSecond
+	}
+
+	h := sha1.New()
+	hashed := 0
+	buf := make([]byte, 16*1024)
+	for hashed < fingerprintSize {
+		pos := d.Position()
+		n, err := d.Read(buf)
+		if err != nil || n == 0 {
+			// Treat errors as end of the file as player does.
+			break
+		}
+		data := buf[:n]
+		if end >= 0 {
+			if left := (end - pos) * frameSize; left < int64(len(data)) {
+				if left < 0 {
+					left = 0
+				}
+				data = data[:left]
+			}
+		}
+		if hashed == 0 {
+			data = skipSilence(data, int(frameSize))
+		}
+		if len(data) > fingerprintSize-hashed {
+			data = data[:fingerprintSize-hashed]
+		}
+		h.Write(data)
+		hashed += len(data)
+		if end >= 0 && pos+int64(n)/frameSize >= end {
+			break
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// skipSilence returns data starting with the first frame which has
+// non-zero samples.
+func skipSilence(data []byte, frameSize int) []byte {
+	for i, b := range data {
+		if b != 0 {
+			return data[i-i%frameSize:]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	silence := strings.Repeat("\x00", 4000)
+	audio := strings.Repeat("chub", 100000)
+	dir := testRoot(t, map[string]string{
+		"a.flac":       silence + audio,
+		"moved/b.flac": silence + audio,
+		"c.flac":       audio,
+		"d.flac":       silence + audio[1:] + "x",
+	})
+	defer os.RemoveAll(dir)
+
+	fingerprint := func(path string, start int, end int) string {
+		p, err := NewPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := &Track{Path: p, Part: end != 0, Start: start, End: end}
+		fp, err := tr.Fingerprint()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return fp
+	}
+
+	a := fingerprint("/a.flac", 0, 0)
+	if fingerprint("/moved/b.flac", 0, 0) != a {
+		t.Fatal("moved file fingerprint differs")
+	}
+	if fingerprint("/c.flac", 0, 0) != a {
+		t.Fatal("leading silence is not skipped")
+	}
+	if fingerprint("/d.flac", 0, 0) == a {
+		t.Fatal("different audio has the same fingerprint")
+	}
+	// 1/75 of a second is 588 samples, 2352 bytes.
+	part := fingerprint("/a.flac", 1, 2)
+	if part == a || part == fingerprint("/a.flac", 1, 3) {
+		t.Fatal("part fingerprint does not depend on its bounds")
+	}
+}
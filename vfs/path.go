@@ -78,6 +78,26 @@ func NewPath(p string) (*Path, error) {
 	}, nil
 }
 
+// MissingTrack returns track for the path which does not exist
+// (anymore), e.g. a playlist track which file was moved or which music
+// directory is not mounted. It keeps track reference until the track
+// is found again and cannot be played.
+func MissingTrack(p string) *Track {
+	pp, n := splitPath(p)
+
+	return &Track{
+		Path: &Path{
+			root:    root,
+			file:    filePath(root, pp),
+			val:     pp,
+			part:    n >= 0,
+			partNum: n,
+		},
+		Tag:     &Tag{Title: path.Base(pp)},
+		Missing: true,
+	}
+}
+
 func (p *Path) Val() string {
 	return p.val
 }
@@ -240,7 +260,7 @@ func readTracks(p *Path) ([]Entry, error) {
 			continue
 		}
 		if cp.Ext() == cueExt {
-			sheet, err := cue.ParseFile(cp.File())
+			sheet, err := parseCueSheet(cp)
 			if err != nil {
 				continue
 			}
@@ -269,91 +289,29 @@ func readTracks(p *Path) ([]Entry, error) {
 		pp, err := p.Child(name)
 		if err != nil {
 			// TODO: Log ignored file.
-		} else {
-			t, err := pp.Track()
-			// Ignore invalid and unsupported tracks.
-			if err == nil {
-				tracks = append(tracks, t)
-
-			}
+			continue
 		}
-	}
-
-	return tracks, nil
-}
-
-func cueSheetTracks(base *Path, sheet *cue.Sheet) ([]Entry, error) {
-	tracks := make([]Entry, 0)
-
-	for fileNum, file := range sheet.Files {
-		for _, track := range file.Tracks {
-			t, err := cueSheetFileTrack(base, sheet,
-				fileNum, track.Number)
-			if err == nil {
-				tracks = append(tracks, t)
+		md, err := format.GetMetadata(pp.File())
+		// Ignore invalid and unsupported tracks.
+		if err != nil {
+			continue
+		}
+		// File with embedded CUE sheet is split into tracks
+		// as if the sheet was next to it.
+		sheet, err := embeddedCueSheet(pp, md)
+		if err == nil && sheet != nil {
+			cueTracks, err := cueSheetTracks(p, sheet)
+			if err == nil && len(cueTracks) > 0 {
+				tracks = append(tracks, cueTracks...)
+				continue
 			}
 		}
+		tracks = append(tracks, newFileTrack(pp, md))
 	}
 
 	return tracks, nil
 }
 
-func cueSheetFileTrack(base *Path, sheet *cue.Sheet, file int, track int) (*Track, error) {
-	if file >= len(sheet.Files) {
-		return nil, errors.New("CUE FILE not found")
-	}
-	f := sheet.Files[file]
-
-	var t *cue.Track
-	var ti int
-	for i := range f.Tracks {
-		if f.Tracks[i].Number == track {
-			t, ti = f.Tracks[i], i
-			break
-		}
-	}
-	if t == nil {
-		return nil, errors.New("CUE TRACK not found")
-	}
-	pth, err := base.ChildPart(f.Name, track)
-	if err != nil {
-		return nil, err
-	}
-
-	i := startIndex(t.Indexes)
-	if i == nil {
-		return nil, errors.New("CUE start INDEX not found")
-	}
-
-	var start int = i.Time.Seconds()
-	var end int
-
-	if ti < len(f.Tracks)-1 {
-		ii := startIndex(f.Tracks[ti+1].Indexes)
-		if ii == nil {
-			return nil, errors.New("CUE start INDEX not found")
-		}
-		end = ii.Time.Seconds()
-	} else {
-		// TODO: Register formats in VFS package.
-		md, err := format.GetMetadata(pth.File())
-		if err != nil {
-			return nil, err
-		}
-		end = md.Length()
-	}
-
-	return &Track{
-		Path:   pth,
-		Tag:    newTag(sheet, t),
-		Length: end - start,
-		Part:   true,
-		Number: t.Number,
-		Start:  start,
-		End:    end,
-	}, nil
-}
-
 func newTrack(p *Path) (*Track, error) {
 	if p.part {
 		sheet, err := cueSheetForFile(p)
@@ -365,40 +323,38 @@ func newTrack(p *Path) (*Track, error) {
 		if sheet == nil {
 			return nil, errors.New("CUE sheet not found")
 		}
-		for fn, file := range sheet.Files {
-			if file.Name == p.Base() {
-				for _, track := range file.Tracks {
-					if track.Number == p.partNum {
-						base, err := p.Parent()
-						if err != nil {
-							return nil, err
-						}
-
-						return cueSheetFileTrack(base,
-							sheet, fn, track.Number)
-					}
-				}
-			}
+		base, err := p.Parent()
+		if err != nil {
+			return nil, err
+		}
+		t, err := cueSheetTrack(base, sheet, p.partNum,
+			make(map[string]format.Metadata))
+		if err != nil {
+			return nil, err
+		}
+		if t.Path.Base() != p.Base() {
+			return nil, errors.New("track not found")
 		}
 
-		return nil, errors.New("track not found")
+		return t, nil
 	} else {
 		md, err := format.GetMetadata(p.File())
 		if err != nil {
 			return nil, err
 		}
 
-		// TODO: Track without tags?
-		return &Track{
-			Path: p,
-			Tag: &Tag{
-				Artist: md.Artist(),
-				Album:  md.Album(),
-				Title:  md.Title(),
-				Number: md.Number(),
-			},
-			Length: md.Length(),
-		}, nil
+		return newFileTrack(p, md), nil
+	}
+}
+
+// newFileTrack returns track which is the whole file.
+func newFileTrack(p *Path, md format.Metadata) *Track {
+	// TODO: Track without tags?
+	return &Track{
+		Path:       p,
+		Tag:        NewTag(md.Tags()),
+		Properties: newProperties(md),
+		Length:     md.Length(),
 	}
 }
 
@@ -437,18 +393,6 @@ func ext(p string) string {
 	return ext
 }
 
-// startIndex returns Index with number 1, which stands for
-// the track start position.
-func startIndex(indexes []*cue.Index) *cue.Index {
-	for _, i := range indexes {
-		if i.Number == 1 {
-			return i
-		}
-	}
-
-	return nil
-}
-
 // splitPath splits given VFS path in format FILENAME:TRACK_NUMBER
 // to filename and track number parts. Returns -1 as track number
 // value if path doesn't represent partial track path.
@@ -468,7 +412,8 @@ func splitPath(p string) (string, int) {
 
 // cueSheetForFile returns parsed CUE file for the given audio file.
 // Example. Given a FLAC file path it returns a CUE sheet found in the
-// same directory which describes the FLAC file.
+// same directory which describes the FLAC file or the one embedded
+// into the FLAC file.
 func cueSheetForFile(p *Path) (*cue.Sheet, error) {
 	base := p.Base()
 	parent, err := p.Parent()
@@ -492,7 +437,7 @@ func cueSheetForFile(p *Path) (*cue.Sheet, error) {
 			if err != nil {
 				continue
 			}
-			sheet, err := cue.ParseFile(child.File())
+			sheet, err := parseCueSheet(child)
 			if err != nil {
 				return nil, err
 			}
@@ -504,21 +449,62 @@ func cueSheetForFile(p *Path) (*cue.Sheet, error) {
 		}
 	}
 
-	return nil, nil
+	md, err := format.GetMetadata(p.File())
+	if err != nil {
+		return nil, err
+	}
+
+	return embeddedCueSheet(p, md)
 }
 
+// newProperties returns audio properties from the metadata or nil
+// if metadata is not available.
+func newProperties(md format.Metadata) *Properties {
+	if md == nil {
+		return nil
+	}
+
+	return &Properties{
+		Codec:      md.Codec(),
+		Bitrate:    md.Bitrate(),
+		BitDepth:   md.BitDepth(),
+		SampleRate: md.SampleRate(),
+		Channels:   md.Channels(),
+	}
+}
+
+// newTag returns tag for the CUE track. Besides standard CUE commands
+// tags are taken from "REM NAME value" comments (e.g. "REM GENRE Rock"
+// or "REM DATE 1987"), track comments take precedence over the sheet ones.
 func newTag(sheet *cue.Sheet, track *cue.Track) *Tag {
 	tag := &Tag{
-		Album:  sheet.Title,
-		Title:  track.Title,
-		Number: track.Number,
+		Album:       sheet.Title,
+		Title:       track.Title,
+		Number:      track.Number,
+		AlbumArtist: sheet.Performer,
+		Isrc:        track.Isrc,
 	}
-
 	if len(track.Performer) > 0 {
 		tag.Artist = track.Performer
 	} else {
 		tag.Artist = sheet.Performer
 	}
+	if len(track.Songwriter) > 0 {
+		tag.Composer = track.Songwriter
+	} else {
+		tag.Composer = sheet.Songwriter
+	}
+	if len(sheet.Catalog) > 0 {
+		tag.Set("catalog", sheet.Catalog)
+	}
+	for _, cs := range [][]string{track.Comments, sheet.Comments} {
+		for _, c := range cs {
+			f := strings.SplitN(c, " ", 2)
+			if len(f) == 2 {
+				tag.Set(f[0], strings.Trim(f[1], "\""))
+			}
+		}
+	}
 
 	return tag
 }
@@ -0,0 +1,98 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vchimishuk/chub/format"
+)
+
+// Folder image names in order of preference. Names are matched
+// ignoring case.
+var pictureNames = []string{"cover", "folder", "front", "album"}
+
+// Folder image MIME types by file extension.
+var pictureTypes = map[string]string{
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"bmp":  "image/bmp",
+}
+
+// Picture returns album cover picture for the track or directory.
+// Picture embedded into track's file is preferred, folder image
+// (cover.jpg, folder.png, etc.) is returned otherwise.
+func (p *Path) Picture() (*format.Picture, error) {
+	dir := p.File()
+	if !p.IsDir() {
+		pic, err := format.GetPicture(p.File())
+		if err == nil && pic != nil {
+			return pic, nil
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	return folderPicture(dir)
+}
+
+// folderPicture returns the most preferred image found in the directory.
+func folderPicture(dir string) (*format.Picture, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	best, file := len(pictureNames), ""
+	for _, name := range names {
+		_, ok := pictureTypes[ext(name)]
+		if !ok {
+			continue
+		}
+		base := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+		for i, n := range pictureNames[:best] {
+			if base == n {
+				best, file = i, name
+				break
+			}
+		}
+	}
+	if file == "" {
+		return nil, errors.New("picture not found")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+
+	return &format.Picture{MIME: pictureTypes[ext(file)], Data: data}, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPicture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chub-vfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = SetRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{
+		"01.mp3":     "",
+		"back.jpg":   "back",
+		"Folder.PNG": "folder",
+		"cover.txt":  "text",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := NewPath("/01.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pic, err := p.Picture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pic.MIME != "image/png" || string(pic.Data) != "folder" {
+		t.Fatalf("unexpected picture %s %q", pic.MIME, pic.Data)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "cover.jpeg"), []byte("cover"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = NewPath("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pic, err = p.Picture()
+	if err != nil || pic.MIME != "image/jpeg" || string(pic.Data) != "cover" {
+		t.Fatal()
+	}
+
+	os.Remove(filepath.Join(dir, "cover.jpeg"))
+	os.Remove(filepath.Join(dir, "Folder.PNG"))
+	if _, err := p.Picture(); err == nil {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tag names as they are returned by Tag.Map.
+const (
+	TagArtist                 = "artist"
+	TagAlbumArtist            = "albumartist"
+	TagAlbum                  = "album"
+	TagTitle                  = "title"
+	TagNumber                 = "tracknumber"
+	TagTrackTotal             = "tracktotal"
+	TagDiscNumber             = "discnumber"
+	TagDiscTotal              = "disctotal"
+	TagDate                   = "date"
+	TagGenre                  = "genre"
+	TagComposer               = "composer"
+	TagIsrc                   = "isrc"
+	TagMusicBrainzTrack       = "musicbrainz_trackid"
+	TagMusicBrainzAlbum       = "musicbrainz_albumid"
+	TagMusicBrainzArtist      = "musicbrainz_artistid"
+	TagMusicBrainzAlbumArtist = "musicbrainz_albumartistid"
+	TagTrackGain              = "replaygain_track_gain"
+	TagTrackPeak              = "replaygain_track_peak"
+	TagAlbumGain              = "replaygain_album_gain"
+	TagAlbumPeak              = "replaygain_album_peak"
+)
+
+// Gain is a ReplayGain adjustment.
+type Gain struct {
+	// Gain in dB.
+	Gain float64
+	// Peak sample amplitude, 1.0 is the full scale. 0 if unknown.
+	Peak float64
+	// PeakOnly is true if there is peak but no gain value.
+	PeakOnly bool
+}
+
+// Track's tag data.
+type Tag struct {
+	// Artist name.
+	Artist string
+	// Album artist name. Differs from the Artist on compilations.
+	AlbumArtist string
+	// Album name.
+	Album string
+	// Track's title.
+	Title string
+	// Track number and total number of tracks on the disc.
+	Number     int
+	TrackTotal int
+	// Disc number and total number of discs in the album.
+	DiscNumber int
+	DiscTotal  int
+	// Release date. Year only or full date in YYYY-MM-DD format.
+	Date     string
+	Genre    string
+	Composer string
+	// International Standard Recording Code.
+	Isrc string
+	// MusicBrainz track, album (release), artist and album
+	// artist identifiers.
+	MusicBrainzTrackID       string
+	MusicBrainzAlbumID       string
+	MusicBrainzArtistID      string
+	MusicBrainzAlbumArtistID string
+	// ReplayGain track and album adjustments, nil if absent.
+	TrackGain *Gain
+	AlbumGain *Gain
+	// All other tags. Keys are lower cased tag names.
+	Extra map[string]string
+}
+
+// NewTag returns tag filled with the given tags. Tag names are case
+// insensitive, FFmpeg, Vorbis comment and ID3v2 frame names are
+// supported. Unknown tags are stored in Extra field.
+func NewTag(tags map[string]string) *Tag {
+	names := make([]string, 0, len(tags))
+	for n := range tags {
+		names = append(names, n)
+	}
+	// Make result independent of map order, when the same tag
+	// is present under different names.
+	sort.Strings(names)
+
+	t := &Tag{}
+	for _, n := range names {
+		t.Set(n, tags[n])
+	}
+
+	return t
+}
+
+// Set sets tag value by its name. Already set value is not changed.
+func (t *Tag) Set(name string, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	switch normalizeName(name) {
+	case "artist", "tpe1", "performer":
+		setString(&t.Artist, value)
+	case "albumartist", "tpe2":
+		setString(&t.AlbumArtist, value)
+	case "album", "talb":
+		setString(&t.Album, value)
+	case "title", "tit2":
+		setString(&t.Title, value)
+	case "track", "tracknumber", "trck":
+		setNumber(&t.Number, &t.TrackTotal, value)
+	case "tracktotal", "totaltracks":
+		setNumber(&t.TrackTotal, nil, value)
+	case "disc", "discnumber", "tpos":
+		setNumber(&t.DiscNumber, &t.DiscTotal, value)
+	case "disctotal", "totaldiscs":
+		setNumber(&t.DiscTotal, nil, value)
+	case "date", "year", "tdrc", "tyer":
+		setString(&t.Date, value)
+	case "genre", "tcon":
+		setString(&t.Genre, value)
+	case "composer", "tcom", "songwriter":
+		setString(&t.Composer, value)
+	case "isrc", "tsrc":
+		setString(&t.Isrc, value)
+	case "musicbrainztrackid":
+		setString(&t.MusicBrainzTrackID, value)
+	case "musicbrainzalbumid":
+		setString(&t.MusicBrainzAlbumID, value)
+	case "musicbrainzartistid":
+		setString(&t.MusicBrainzArtistID, value)
+	case "musicbrainzalbumartistid":
+		setString(&t.MusicBrainzAlbumArtistID, value)
+	case "replaygaintrackgain":
+		setGain(&t.TrackGain, false, value)
+	case "replaygaintrackpeak":
+		setGain(&t.TrackGain, true, value)
+	case "replaygainalbumgain":
+		setGain(&t.AlbumGain, false, value)
+	case "replaygainalbumpeak":
+		setGain(&t.AlbumGain, true, value)
+	default:
+		if t.Extra == nil {
+			t.Extra = make(map[string]string)
+		}
+		n := strings.ToLower(name)
+		if _, ok := t.Extra[n]; !ok {
+			t.Extra[n] = value
+		}
+	}
+}
+
+// Map returns all non-empty tags. NewTag(t.Map()) returns
+// the same tag.
+func (t *Tag) Map() map[string]string {
+	m := make(map[string]string, len(t.Extra)+16)
+	for k, v := range t.Extra {
+		m[k] = v
+	}
+	for n, v := range map[string]string{
+		TagArtist:                 t.Artist,
+		TagAlbumArtist:            t.AlbumArtist,
+		TagAlbum:                  t.Album,
+		TagTitle:                  t.Title,
+		TagDate:                   t.Date,
+		TagGenre:                  t.Genre,
+		TagComposer:               t.Composer,
+		TagIsrc:                   t.Isrc,
+		TagMusicBrainzTrack:       t.MusicBrainzTrackID,
+		TagMusicBrainzAlbum:       t.MusicBrainzAlbumID,
+		TagMusicBrainzArtist:      t.MusicBrainzArtistID,
+		TagMusicBrainzAlbumArtist: t.MusicBrainzAlbumArtistID,
+	} {
+		if v != "" {
+			m[n] = v
+		}
+	}
+	for _, g := range []struct {
+		gain *Gain
+		name string
+		peak string
+	}{
+		{t.TrackGain, TagTrackGain, TagTrackPeak},
+		{t.AlbumGain, TagAlbumGain, TagAlbumPeak},
+	} {
+		if g.gain == nil {
+			continue
+		}
+		if !g.gain.PeakOnly {
+			m[g.name] = strconv.FormatFloat(g.gain.Gain, 'f', 2, 64) + " dB"
+		}
+		if g.gain.Peak != 0 {
+			m[g.peak] = strconv.FormatFloat(g.gain.Peak, 'f', 6, 64)
+		}
+	}
+	for n, v := range map[string]int{
+		TagNumber:     t.Number,
+		TagTrackTotal: t.TrackTotal,
+		TagDiscNumber: t.DiscNumber,
+		TagDiscTotal:  t.DiscTotal,
+	} {
+		if v != 0 {
+			m[n] = strconv.Itoa(v)
+		}
+	}
+
+	return m
+}
+
+// Equal returns true if both tags have the same values.
+func (t *Tag) Equal(o *Tag) bool {
+	if t == nil || o == nil {
+		return t == o
+	}
+
+	return reflect.DeepEqual(t.Map(), o.Map())
+}
+
+// normalizeName returns lower cased tag name without spaces,
+// underscores and dashes, so "MusicBrainz Track Id" and
+// "MUSICBRAINZ_TRACKID" are the same tag.
+func normalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(name))
+}
+
+func setString(s *string, v string) {
+	if *s == "" {
+		*s = v
+	}
+}
+
+// setGain parses ReplayGain gain ("-6.20 dB") or peak ("0.988") value.
+func setGain(g **Gain, peak bool, v string) {
+	v = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(v), "db"))
+	x, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return
+	}
+	if *g == nil {
+		*g = &Gain{PeakOnly: true}
+	}
+	if peak && (*g).Peak == 0 {
+		(*g).Peak = x
+	} else if !peak && (*g).PeakOnly {
+		(*g).Gain = x
+		(*g).PeakOnly = false
+	}
+}
+
+// setNumber parses number in N or N/TOTAL format.
+func setNumber(n *int, total *int, v string) {
+	nv, tv := v, ""
+	if i := strings.Index(v, "/"); i >= 0 {
+		nv, tv = v[:i], v[i+1:]
+	}
+	if x, err := strconv.Atoi(strings.TrimSpace(nv)); err == nil && *n == 0 {
+		*n = x
+	}
+	if total == nil {
+		return
+	}
+	if x, err := strconv.Atoi(strings.TrimSpace(tv)); err == nil && *total == 0 {
+		*total = x
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 Viacheslav Chimishuk <vchimishuk@yandex.ru>
+//
+// This file is part of Chub.
+//
+// Chub is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Chub is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Chub. If not, see <http://www.gnu.org/licenses/>.
+
+package vfs
+
+import "testing"
+
+func TestNewTag(t *testing.T) {
+	tag := NewTag(map[string]string{
+		"ARTIST":                "Doro",
+		"TPE1":                  "Warlock",
+		"album_artist":          "Various Artists",
+		"TRCK":                  "3/12",
+		"DISCNUMBER":            "1",
+		"TOTALDISCS":            "2",
+		"year":                  "1987",
+		"MusicBrainz Track Id":  "8f2b1c4e",
+		"Mood":                  "loud",
+		"comment":               " ",
+		"REPLAYGAIN_TRACK_GAIN": "-6.20 dB",
+		"replaygain_track_peak": "0.988",
+	})
+	if tag.Artist != "Doro" || tag.AlbumArtist != "Various Artists" ||
+		tag.Number != 3 || tag.TrackTotal != 12 ||
+		tag.DiscNumber != 1 || tag.DiscTotal != 2 ||
+		tag.Date != "1987" || tag.MusicBrainzTrackID != "8f2b1c4e" {
+		t.Fatalf("unexpected tag: %+v", tag)
+	}
+	if tag.TrackGain == nil || *tag.TrackGain != (Gain{Gain: -6.2, Peak: 0.988}) ||
+		tag.AlbumGain != nil {
+		t.Fatalf("unexpected gain: %v", tag.TrackGain)
+	}
+	peak := NewTag(map[string]string{"replaygain_album_peak": "0.9"})
+	if peak.AlbumGain == nil || !peak.AlbumGain.PeakOnly ||
+		!NewTag(peak.Map()).Equal(peak) {
+		t.Fatalf("unexpected gain: %v", peak.AlbumGain)
+	}
+	if len(tag.Extra) != 1 || tag.Extra["mood"] != "loud" {
+		t.Fatalf("unexpected extra tags: %v", tag.Extra)
+	}
+	if !NewTag(tag.Map()).Equal(tag) {
+		t.Fatal()
+	}
+	if tag.Equal(&Tag{Artist: "Doro"}) {
+		t.Fatal()
+	}
+}